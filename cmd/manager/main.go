@@ -13,6 +13,7 @@ import (
 	ca "github.com/operator-framework/operator-marketplace/pkg/certificateauthority"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -31,6 +32,7 @@ import (
 	"github.com/operator-framework/operator-marketplace/pkg/controller/options"
 	"github.com/operator-framework/operator-marketplace/pkg/defaults"
 	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/operator-framework/operator-marketplace/pkg/operatorhub"
 	"github.com/operator-framework/operator-marketplace/pkg/signals"
 	"github.com/operator-framework/operator-marketplace/pkg/status"
 	sourceCommit "github.com/operator-framework/operator-marketplace/pkg/version"
@@ -85,16 +87,26 @@ func main() {
 	printVersion()
 
 	var (
-		clusterOperatorName         string
-		tlsKeyPath                  string
-		tlsCertPath                 string
-		leaderElectionNamespace     string
-		pprofAddress                string
-		version                     bool
-		loglvl                      string
+		clusterOperatorName     string
+		tlsKeyPath              string
+		tlsCertPath             string
+		leaderElectionNamespace string
+		pprofAddress            string
+		version                 bool
+		loglvl                  string
+		watchDefaults           bool
+		resyncInterval          time.Duration
 	)
 	flag.StringVar(&clusterOperatorName, "clusterOperatorName", "", "configures the name of the OpenShift ClusterOperator that should reflect this operator's status, or the empty string to disable ClusterOperator updates")
 	flag.StringVar(&defaults.Dir, "defaultsDir", "", "configures the directory where the default CatalogSources are stored")
+	flag.BoolVar(&watchDefaults, "watchDefaults", false, "reloads the default CatalogSources when the contents of the defaultsDir change")
+	flag.DurationVar(&resyncInterval, "defaultsResyncInterval", 15*time.Minute, "reapplies the OperatorHub configuration to the default CatalogSources at this interval, or never if it is 0")
+	flag.IntVar(&defaults.EnsureWorkers, "defaultsWorkers", defaults.EnsureWorkers, "the number of default CatalogSources that are ensured concurrently")
+	flag.BoolVar(&defaults.MirrorImages, "mirrorDefaultImages", false, "rewrites the images of the default CatalogSources to the mirrors configured by the cluster's ImageDigestMirrorSets and ImageTagMirrorSets")
+	flag.BoolVar(&defaults.DryRun, "dry-run", false, "computes and reports the actions on the default CatalogSources without taking them")
+	flag.Var(&defaults.OrphanedDefaultsPolicy, "orphanedDefaultsPolicy", "what to do with CatalogSources managed by the operator that no longer have a default definition: delete, keep or warn")
+	flag.Var(&defaults.DefaultsAdoptionPolicy, "defaultsAdoptionPolicy", "whether CatalogSources that have the name of a default CatalogSource but were not created by the operator are taken over: always, never or only-if-spec-matches")
+	flag.Var(&defaults.DefaultsConflictPolicy, "defaultsConflictPolicy", "whether fields of the default CatalogSources and their companion objects that other field managers took over are taken back: force (the default) or report")
 	flag.BoolVar(&version, "version", false, "displays marketplace source commit info.")
 	flag.StringVar(&pprofAddress, "pprof-address", fmt.Sprintf(":%d", defaultPprofPort), "Address to serve pprof endpoints on.")
 	flag.StringVar(&tlsKeyPath, "tls-key", "", "Path to use for private key (requires tls-cert)")
@@ -137,6 +149,11 @@ func main() {
 	logger.Info("setting up scheme")
 	scheme := setupScheme()
 
+	catalogPods, err := labels.Parse(defaults.CatalogSourceLabelKey)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	// Even though we are asking to watch all namespaces, we only handle events
 	// from the operator's namespace. The reason for watching all namespaces is
 	// watch for CatalogSources in targetNamespaces being deleted and recreate
@@ -166,6 +183,14 @@ func main() {
 						},
 					},
 				},
+				// Only the pods of the CatalogSources in the operator's
+				// namespace are read, to diagnose the default CatalogSources
+				&corev1.Pod{}: {
+					Namespaces: map[string]cache.Config{
+						namespace: {},
+					},
+					Label: catalogPods,
+				},
 			},
 		},
 	})
@@ -220,18 +245,30 @@ func main() {
 		}
 
 		operatorReleaseVersion := os.Getenv("RELEASE_VERSION")
-		overrideTag, err := defaults.GetCatalogSourceImageTagOverride(operatorReleaseVersion)
+		releaseVersion, err := defaults.ParseReleaseVersion(operatorReleaseVersion)
 		if err != nil {
-			overrideTag = ""
-			logger.Warnf("failed to parse RELEASE_VERSION %q for default CatalogSource image tag override: %v (skipping override)", operatorReleaseVersion, err)
+			releaseVersion = nil
+			logger.Warnf("failed to parse RELEASE_VERSION %q for rendering the default CatalogSources: %v (treating it as unknown)", operatorReleaseVersion, err)
 		}
 
-		if len(overrideTag) > 0 {
-			logger.Infof("applying image tag override %s to default CatalogSources based on RELEASE_VERSION %s", overrideTag, operatorReleaseVersion)
+		// The cluster Infrastructure is read directly as the manager's cache
+		// has not been started yet
+		var infra *apiconfigv1.Infrastructure
+		if configv1.IsAPIAvailable() {
+			infra = &apiconfigv1.Infrastructure{}
+			if err := mgr.GetAPIReader().Get(ctx, client.ObjectKey{Name: "cluster"}, infra); err != nil {
+				infra = nil
+				logger.Warnf("failed to get the cluster Infrastructure for rendering the default CatalogSources: %v", err)
+			}
 		}
-
-		// Populate the global default CatalogSource definitions and config
-		if err := defaults.PopulateGlobals(overrideTag); err != nil {
+		templateContext := defaults.NewTemplateContext(namespace, releaseVersion, infra)
+		logger.Infof("rendering the default CatalogSources for namespace %q, release version %q, control plane topology %q and platform %q",
+			templateContext.WatchNamespace, templateContext.ReleaseVersion, templateContext.ControlPlaneTopology, templateContext.Platform)
+
+		// Populate the global default CatalogSource definitions and config. This
+		// only fails if none of the definitions could be loaded, files that fail
+		// to load are otherwise skipped and reported as Degraded.
+		if err := defaults.PopulateGlobals(templateContext); err != nil {
 			logger.Fatal(err)
 		}
 
@@ -240,6 +277,28 @@ func main() {
 			logger.Fatal(err)
 		}
 
+		// The watcher reapplies the configuration through the manager's
+		// client, so it is run by the manager once its caches are started
+		if watchDefaults {
+			logger.Infof("watching %s for changes to the default CatalogSources", defaults.Dir)
+			watcher, err := defaults.NewWatcher(logger, func(ctx context.Context) error {
+				_, err := operatorhub.Refresh(ctx, mgr.GetClient(), mgr.GetEventRecorder(defaults.EventRecorderName))
+				return err
+			})
+			if err != nil {
+				logger.Fatal(err)
+			}
+			if err := mgr.Add(watcher); err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		if resyncInterval > 0 {
+			if err := mgr.Add(operatorhub.NewResync(mgr.GetClient(), mgr.GetEventRecorder(defaults.EventRecorderName), resyncInterval)); err != nil {
+				logger.Fatal(err)
+			}
+		}
+
 		// Start APIServer TLS informer factory if on OpenShift
 		if apiServerFactory != nil {
 			apiServerFactory.Start(ctx.Done())
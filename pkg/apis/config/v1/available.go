@@ -46,3 +46,10 @@ func SetConfigAPIAvailability(cfg *rest.Config) error {
 func IsAPIAvailable() bool {
 	return isAPIAvailable
 }
+
+// SetAPIAvailable overrides whether or not the config API is available, in
+// place of the discovery done by SetConfigAPIAvailability. It is meant for
+// tests.
+func SetAPIAvailable(available bool) {
+	isAPIAvailable = available
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -20,6 +21,7 @@ type Client interface {
 	Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error
 	Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error
 	List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error
+	Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error
 }
 
 // kubeClient is an implementation of the Client interface
@@ -59,3 +61,8 @@ func (h *kubeClient) Delete(ctx context.Context, obj client.Object, opts ...clie
 func (h *kubeClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
 	return h.client.List(ctx, list, opts...)
 }
+
+// Apply applies a runtime object in the cluster using server-side apply
+func (h *kubeClient) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+	return h.client.Apply(ctx, obj, opts...)
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/operator-framework/operator-marketplace/pkg/defaults"
 	"github.com/operator-framework/operator-marketplace/pkg/operatorhub"
 
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -26,24 +28,24 @@ func Add(mgr manager.Manager, _ options.ControllerOptions) error {
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 	client := mgr.GetClient()
 	return &ReconcileCatalogSource{
-		client: client,
+		client:   client,
+		cache:    mgr.GetCache(),
+		recorder: mgr.GetEventRecorder(defaults.EventRecorderName),
 	}
 }
 
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	defaultCatalogsources := defaults.GetGlobalCatalogSourceDefinitions()
+	// The default definitions can be reloaded at runtime, so they are looked
+	// up on every event instead of being captured here.
 	pred := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			return false
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			if _, ok := defaultCatalogsources[e.ObjectOld.GetName()]; ok {
-				return true
-			}
-			return false
+			return defaults.IsDefaultSource(e.ObjectOld.GetName())
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
-			if _, ok := defaultCatalogsources[e.Object.GetName()]; ok {
+			if defaults.IsDefaultSource(e.Object.GetName()) {
 				// If DeleteStateUnknown is true it implies that the Delete event was missed
 				// and we can ignore it.
 				if e.DeleteStateUnknown {
@@ -54,10 +56,7 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			return false
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
-			if _, ok := defaultCatalogsources[e.Object.GetName()]; ok {
-				return true
-			}
-			return false
+			return defaults.IsDefaultSource(e.Object.GetName())
 		},
 	}
 
@@ -76,9 +75,29 @@ type ReconcileCatalogSource struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
+	// cache reads the OperatorHub the events are also recorded on, which is
+	// looked up on every event
+	cache    client.Reader
+	recorder events.EventRecorder
 }
 
 func (r *ReconcileCatalogSource) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	defaultCatalogsources := defaults.GetGlobalCatalogSourceDefinitions()
-	return reconcile.Result{}, defaults.New(defaultCatalogsources, operatorhub.GetSingleton().Get()).Ensure(ctx, r.client, request.Name)
+	companions := defaults.GetGlobalCompanions()
+	current := operatorhub.GetSingleton()
+	hub := operatorhub.GetEventTarget(ctx, r.cache)
+	result := defaults.New(defaultCatalogsources, current.Get(),
+		defaults.WithCompanions(companions),
+		defaults.WithOverrides(current.GetOverrides()),
+		defaults.WithEventRecorder(r.recorder, hub),
+		defaults.WithDryRun(current.GetDryRun()),
+	).EnsureSource(ctx, r.client, request.Name)
+	// A CatalogSource that is being deleted is checked again until it is
+	// gone, as its Delete event may be missed. One that failed with a
+	// retriable error is requeued rather than retried by the worker.
+	if result.Err != nil && result.RequeueAfter > 0 {
+		log.Warnf("Requeuing default CatalogSource %s in %s - %v", request.Name, result.RequeueAfter, result.Err)
+		return reconcile.Result{RequeueAfter: result.RequeueAfter}, nil
+	}
+	return reconcile.Result{RequeueAfter: result.RequeueAfter}, result.Err
 }
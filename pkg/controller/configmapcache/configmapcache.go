@@ -0,0 +1,32 @@
+package configmapcache
+
+import (
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// New returns a cache of the ConfigMaps in namespace that match the given
+// selectors, either of which may be nil, and adds it to mgr so that it is
+// started along with it.
+//
+// The manager's cache only holds the ConfigMaps used for certificate
+// authorities, so the ConfigMaps the operator is configured through are read
+// through a dedicated cache that only holds them.
+func New(mgr manager.Manager, namespace string, labelSelector labels.Selector, fieldSelector fields.Selector) (cache.Cache, error) {
+	configMapCache, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:               mgr.GetScheme(),
+		Mapper:               mgr.GetRESTMapper(),
+		DefaultNamespaces:    map[string]cache.Config{namespace: {}},
+		DefaultLabelSelector: labelSelector,
+		DefaultFieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mgr.Add(configMapCache); err != nil {
+		return nil, err
+	}
+	return configMapCache, nil
+}
@@ -0,0 +1,110 @@
+package defaultsconfigmap
+
+import (
+	"context"
+
+	"github.com/operator-framework/operator-marketplace/pkg/apis/operators/shared"
+	"github.com/operator-framework/operator-marketplace/pkg/controller/configmapcache"
+	"github.com/operator-framework/operator-marketplace/pkg/controller/options"
+	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"github.com/operator-framework/operator-marketplace/pkg/operatorhub"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// requestName is the name of the single request all defaults ConfigMap events
+// are mapped to, as the definitions are always recomputed from all of them.
+const requestName = "default-catalogsources"
+
+// Add creates a new defaults ConfigMap Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager, _ options.ControllerOptions) error {
+	namespace, err := shared.GetWatchNamespace()
+	if err != nil {
+		return err
+	}
+
+	configMapCache, err := configmapcache.New(mgr, namespace, labels.SelectorFromSet(labels.Set{defaults.ConfigMapLabelKey: defaults.ConfigMapLabelValue}), nil)
+	if err != nil {
+		return err
+	}
+	defaults.ExpectConfigMapDefinitions()
+
+	return add(mgr, newReconciler(mgr, configMapCache, namespace), configMapCache, namespace)
+}
+
+// newReconciler returns a new ReconcileDefaultsConfigMap.
+func newReconciler(mgr manager.Manager, reader client.Reader, namespace string) *ReconcileDefaultsConfigMap {
+	return &ReconcileDefaultsConfigMap{
+		client:    mgr.GetClient(),
+		recorder:  mgr.GetEventRecorder(defaults.EventRecorderName),
+		reader:    reader,
+		namespace: namespace,
+	}
+}
+
+// add adds a new Controller to mgr with r as the ReconcileDefaultsConfigMap.
+func add(mgr manager.Manager, r *ReconcileDefaultsConfigMap, configMapCache cache.Cache, namespace string) error {
+	mapFn := func(_ context.Context, _ *corev1.ConfigMap) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: requestName, Namespace: namespace}}}
+	}
+
+	return builder.ControllerManagedBy(mgr).
+		Named("defaults-configmap-controller").
+		WatchesRawSource(source.Kind(configMapCache, &corev1.ConfigMap{}, handler.TypedEnqueueRequestsFromMapFunc(mapFn))).
+		Complete(r)
+}
+
+var _ reconcile.Reconciler = &ReconcileDefaultsConfigMap{}
+
+// ReconcileDefaultsConfigMap loads default CatalogSource definitions from the
+// labeled ConfigMaps in the operator's namespace.
+type ReconcileDefaultsConfigMap struct {
+	// client is used to apply the default CatalogSources
+	client client.Client
+	// recorder records the events about the default CatalogSources
+	recorder events.EventRecorder
+	// reader reads the labeled ConfigMaps from their dedicated cache
+	reader    client.Reader
+	namespace string
+	// refreshPending is set while changed definitions have not been applied
+	// successfully, so that a failed refresh is retried on requeue.
+	refreshPending bool
+}
+
+// Reconcile recomputes the default CatalogSource definitions from all the
+// labeled ConfigMaps and reapplies the OperatorHub configuration if they
+// changed.
+func (r *ReconcileDefaultsConfigMap) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log.Infof("Reconciling default CatalogSource ConfigMaps in %s", r.namespace)
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := r.reader.List(ctx, configMaps, client.InNamespace(r.namespace)); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if defaults.SetConfigMapDefinitions(configMaps.Items) {
+		log.Info("[defaults] Default CatalogSource definitions from ConfigMaps changed, reapplying the OperatorHub configuration")
+		r.refreshPending = true
+	}
+	if !r.refreshPending {
+		return reconcile.Result{}, nil
+	}
+
+	requeueAfter, err := operatorhub.Refresh(ctx, r.client, r.recorder)
+	if err != nil {
+		return operatorhub.Requeue(requeueAfter, err)
+	}
+	r.refreshPending = false
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
@@ -5,12 +5,18 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 
 	configv1 "github.com/openshift/api/config/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
 	mktconfig "github.com/operator-framework/operator-marketplace/pkg/apis/config/v1"
 	"github.com/operator-framework/operator-marketplace/pkg/controller/options"
+	"github.com/operator-framework/operator-marketplace/pkg/defaults"
 	"github.com/operator-framework/operator-marketplace/pkg/operatorhub"
 	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -25,9 +31,11 @@ func Add(mgr manager.Manager, _ options.ControllerOptions) error {
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 	client := mgr.GetClient()
+	recorder := mgr.GetEventRecorder(defaults.EventRecorderName)
 	return &ReconcileOperatorHub{
-		client:  client,
-		handler: operatorhub.NewHandler(client),
+		client:   client,
+		recorder: recorder,
+		handler:  operatorhub.NewHandler(client, recorder),
 	}
 }
 
@@ -52,15 +60,9 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			return false
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
-			if e.Object.GetName() == operatorhub.DefaultName {
-				// If DeleteStateUnknown is true it implies that the Delete event was missed
-				// and we can ignore it.
-				if e.DeleteStateUnknown {
-					return false
-				}
-				return true
-			}
-			return false
+			// The deletion is handled even if the Delete event was missed, as
+			// the configuration must be reset either way
+			return e.Object.GetName() == operatorhub.DefaultName
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
 			if e.Object.GetName() == operatorhub.DefaultName {
@@ -70,12 +72,28 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		},
 	}
 
-	return builder.ControllerManagedBy(mgr).
+	// The status of each default CatalogSource in the OperatorHub reflects the
+	// state of its registry connection, so changes to it requeue the cluster
+	// OperatorHub.
+	b := builder.ControllerManagedBy(mgr).
 		Named("operatorhub-controller").
-		For(&configv1.OperatorHub{}).
-		WithEventFilter(pred).
-		Complete(r)
+		For(&configv1.OperatorHub{}, builder.WithPredicates(pred)).
+		Watches(&olmv1alpha1.CatalogSource{}, handler.EnqueueRequestsFromMapFunc(requeueOperatorHub), builder.WithPredicates(operatorhub.HealthPredicate()))
+
+	// The images of the default CatalogSources depend on the mirror
+	// configuration, so any change to it requeues the cluster OperatorHub.
+	if defaults.MirrorImages {
+		b = b.Watches(&configv1.ImageDigestMirrorSet{}, handler.EnqueueRequestsFromMapFunc(requeueOperatorHub)).
+			Watches(&configv1.ImageTagMirrorSet{}, handler.EnqueueRequestsFromMapFunc(requeueOperatorHub))
+	}
+
+	return b.Complete(r)
+
+}
 
+// requeueOperatorHub maps any object to the cluster OperatorHub
+func requeueOperatorHub(_ context.Context, _ client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: operatorhub.DefaultName}}}
 }
 
 // blank assignment to verify that ReconcileOperatorHub implements reconcile.Reconciler
@@ -85,8 +103,9 @@ var _ reconcile.Reconciler = &ReconcileOperatorHub{}
 type ReconcileOperatorHub struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client  client.Client
-	handler operatorhub.Handler
+	client   client.Client
+	recorder events.EventRecorder
+	handler  operatorhub.Handler
 }
 
 // Reconcile reads that state of the cluster for a OperatorHub object and makes changes based on the state read
@@ -97,13 +116,19 @@ func (r *ReconcileOperatorHub) Reconcile(ctx context.Context, request reconcile.
 	// Fetch the OperatorHub instance
 	instance := &configv1.OperatorHub{}
 	if err := r.client.Get(ctx, request.NamespacedName, instance); err != nil {
-		// Request object not found, could have been deleted after reconcile request.
-		// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+		// The configuration of a deleted cluster OperatorHub no longer
+		// applies to the default CatalogSources
+		if apierrors.IsNotFound(err) && request.Name == operatorhub.DefaultName {
+			return operatorhub.Requeue(operatorhub.HandleDeletion(ctx, r.client, r.recorder))
+		}
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
-	if err := r.handler.Handle(ctx, instance); err != nil {
+	// The default CatalogSources that failed with a retriable error are
+	// reported in the status and ensured again once the reconcile is requeued
+	requeueAfter, err := r.handler.Handle(ctx, instance)
+	if err != nil {
 		return reconcile.Result{}, err
 	}
 
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
 }
@@ -0,0 +1,110 @@
+package operatorhubconfigmap
+
+import (
+	"context"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	mktconfig "github.com/operator-framework/operator-marketplace/pkg/apis/config/v1"
+	"github.com/operator-framework/operator-marketplace/pkg/apis/operators/shared"
+	"github.com/operator-framework/operator-marketplace/pkg/controller/configmapcache"
+	"github.com/operator-framework/operator-marketplace/pkg/controller/options"
+	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"github.com/operator-framework/operator-marketplace/pkg/operatorhub"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Add creates a new OperatorHub ConfigMap Controller and adds it to the Manager. The Manager will set fields on the
+// Controller and Start it when the Manager is Started. The ConfigMap only replaces the cluster OperatorHub on clusters
+// without the config API.
+func Add(mgr manager.Manager, _ options.ControllerOptions) error {
+	if mktconfig.IsAPIAvailable() {
+		return nil
+	}
+
+	namespace, err := shared.GetWatchNamespace()
+	if err != nil {
+		return err
+	}
+
+	configMapCache, err := configmapcache.New(mgr, namespace, nil, fields.OneTermEqualSelector("metadata.name", operatorhub.ConfigMapName))
+	if err != nil {
+		return err
+	}
+	operatorhub.UseConfigMap(configMapCache, namespace)
+
+	return add(mgr, newReconciler(mgr, configMapCache), configMapCache, namespace)
+}
+
+// newReconciler returns a new ReconcileOperatorHubConfigMap.
+func newReconciler(mgr manager.Manager, reader client.Reader) *ReconcileOperatorHubConfigMap {
+	return &ReconcileOperatorHubConfigMap{
+		client:   mgr.GetClient(),
+		recorder: mgr.GetEventRecorder(defaults.EventRecorderName),
+		reader:   reader,
+	}
+}
+
+// add adds a new Controller to mgr with r as the ReconcileOperatorHubConfigMap.
+func add(mgr manager.Manager, r *ReconcileOperatorHubConfigMap, configMapCache cache.Cache, namespace string) error {
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: operatorhub.ConfigMapName, Namespace: namespace}}
+	mapFn := func(_ context.Context, _ *corev1.ConfigMap) []reconcile.Request {
+		return []reconcile.Request{request}
+	}
+	requeueConfigMap := func(_ context.Context, _ client.Object) []reconcile.Request {
+		return []reconcile.Request{request}
+	}
+
+	// As for the cluster OperatorHub, changes to the registry connection of
+	// the default CatalogSources requeue the ConfigMap.
+	return builder.ControllerManagedBy(mgr).
+		Named("operatorhub-configmap-controller").
+		WatchesRawSource(source.Kind(configMapCache, &corev1.ConfigMap{}, handler.TypedEnqueueRequestsFromMapFunc(mapFn))).
+		Watches(&olmv1alpha1.CatalogSource{}, handler.EnqueueRequestsFromMapFunc(requeueConfigMap), builder.WithPredicates(operatorhub.HealthPredicate())).
+		Complete(r)
+}
+
+var _ reconcile.Reconciler = &ReconcileOperatorHubConfigMap{}
+
+// ReconcileOperatorHubConfigMap applies the OperatorHub configuration held by
+// the OperatorHub ConfigMap in the operator's namespace.
+type ReconcileOperatorHubConfigMap struct {
+	// client is used to apply the default CatalogSources and write the
+	// status of the ConfigMap
+	client client.Client
+	// recorder records the events about the default CatalogSources
+	recorder events.EventRecorder
+	// reader reads the OperatorHub ConfigMap from its dedicated cache
+	reader client.Reader
+}
+
+// Reconcile applies the configuration of the OperatorHub ConfigMap to the
+// default CatalogSources, or the default configuration once it is deleted.
+func (r *ReconcileOperatorHubConfigMap) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log.Infof("Reconciling OperatorHub ConfigMap %s/%s", request.Namespace, request.Name)
+
+	cm := &corev1.ConfigMap{}
+	if err := r.reader.Get(ctx, request.NamespacedName, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return operatorhub.Requeue(operatorhub.HandleConfigMapDeletion(ctx, r.client, r.recorder))
+		}
+		return reconcile.Result{}, err
+	}
+	requeueAfter, err := operatorhub.HandleConfigMap(ctx, r.client, r.recorder, cm)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
@@ -0,0 +1,90 @@
+package defaults
+
+import (
+	"context"
+	"fmt"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AdoptionPolicy decides whether a CatalogSource that has the name of a
+// default CatalogSource, but was not created by the operator, is taken over
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyAlways always takes over the CatalogSource and enforces
+	// the definition on it
+	AdoptionPolicyAlways AdoptionPolicy = "always"
+	// AdoptionPolicyNever never takes over the CatalogSource
+	AdoptionPolicyNever AdoptionPolicy = "never"
+	// AdoptionPolicyIfSpecMatches only takes over the CatalogSource if its
+	// spec already matches the definition
+	AdoptionPolicyIfSpecMatches AdoptionPolicy = "only-if-spec-matches"
+)
+
+// DefaultsAdoptionPolicy is the policy applied to the CatalogSources that
+// have the name of a default CatalogSource but were not created by the
+// operator
+var DefaultsAdoptionPolicy = AdoptionPolicyAlways
+
+// String implements flag.Value
+func (p *AdoptionPolicy) String() string {
+	return string(*p)
+}
+
+// Set implements flag.Value
+func (p *AdoptionPolicy) Set(value string) error {
+	switch AdoptionPolicy(value) {
+	case AdoptionPolicyAlways, AdoptionPolicyNever, AdoptionPolicyIfSpecMatches:
+		*p = AdoptionPolicy(value)
+		return nil
+	}
+	return fmt.Errorf("unsupported policy %q, it must be one of %s, %s or %s", value, AdoptionPolicyAlways, AdoptionPolicyNever, AdoptionPolicyIfSpecMatches)
+}
+
+// adoptCatsrc takes over the given CatalogSource, which has the name of the
+// default CatalogSource def but is not annotated as managed by the operator,
+// if DefaultsAdoptionPolicy allows it. Otherwise the CatalogSource is left as
+// it is and reported as a conflict.
+func adoptCatsrc(
+	ctx context.Context,
+	client wrapper.Client,
+	recorder *eventRecorder,
+	def *olmv1alpha1.CatalogSource,
+	desired *unstructured.Unstructured,
+	cluster *olmv1alpha1.CatalogSource,
+	dryRun bool,
+) (Action, error) {
+	policy := DefaultsAdoptionPolicy
+	adopt := policy == AdoptionPolicyAlways
+	if policy == AdoptionPolicyIfSpecMatches {
+		current, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cluster)
+		if err != nil {
+			return ActionNone, err
+		}
+		adopt = isSubset(desired.Object["spec"], current["spec"])
+	}
+
+	if !adopt {
+		logrus.Warnf("[defaults] CatalogSource %s was not created by the operator and is not adopted as the adoption policy is %s", def.Name, policy)
+		recorder.warningOnChange(cluster, NotAdoptedReason, "Adopt", "The CatalogSource has the name of a default CatalogSource but was not created by the operator, it is not adopted as the adoption policy is %s", policy)
+		return ActionConflict, nil
+	}
+
+	if dryRun {
+		reportDryRun(recorder, cluster, ActionAdopt, "adopt the CatalogSource and enforce its default definition")
+		return ActionAdopt, nil
+	}
+	// The fields set by the previous owner of the CatalogSource are taken
+	// over without reporting them as drift
+	if _, err := applyCatsrc(ctx, client, desired, true); err != nil {
+		return ActionNone, err
+	}
+	logrus.Infof("[defaults] Adopting CatalogSource %s", def.Name)
+	recorder.normal(cluster, AdoptedReason, "Adopt", "Adopted the CatalogSource and enforced its default definition")
+	return ActionAdopt, nil
+}
@@ -1,63 +1,154 @@
 package defaults
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
 	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
 	"github.com/sirupsen/logrus"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func ensureCatsrc(
 	ctx context.Context,
 	client wrapper.Client,
+	recorder *eventRecorder,
 	config map[string]bool,
 	catsrc olmv1alpha1.CatalogSource,
-) error {
+	companions []unstructured.Unstructured,
+	dryRun bool,
+) (Action, error) {
 	disable, present := config[catsrc.Name]
 	if !present {
 		disable = false
 	}
 
-	err := processCatsrc(ctx, client, catsrc, disable)
+	return processCatsrc(ctx, client, recorder, catsrc, companions, disable, dryRun)
+}
+
+// getDefinitions returns the CatalogSource definitions and companion objects
+// found in the given file, after rendering it with the given TemplateContext.
+// The file may contain multiple YAML documents and each document may either be
+// a CatalogSource, a companion object or a list of them. Any other resource
+// type will result in an error.
+func getDefinitions(path string, templateContext TemplateContext) ([]olmv1alpha1.CatalogSource, []unstructured.Unstructured, error) {
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	content, err = renderDefinitions(filepath.Base(path), content, templateContext)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return decodeDefinitions(bytes.NewReader(content))
 }
 
-// getCatsrcDefinition returns a CatalogSource definition from the given file
-// in the defaults directory. It only supports decoding CatalogSources. Any
-// other resource type will result in an error.
-func getCatsrcDefinition(fileName string) (*olmv1alpha1.CatalogSource, error) {
-	file, err := os.Open(filepath.Join(Dir, fileName))
-	if err != nil {
-		return nil, err
+// decodeDefinitions returns the CatalogSource definitions and companion
+// objects decoded from the YAML or JSON documents in the given reader.
+func decodeDefinitions(r io.Reader) ([]olmv1alpha1.CatalogSource, []unstructured.Unstructured, error) {
+	var catsrcs []olmv1alpha1.CatalogSource
+	var companions []unstructured.Unstructured
+	decodeObject := func(obj *unstructured.Unstructured) error {
+		if isCompanionKind(obj) {
+			companion, err := toCompanion(obj)
+			if err != nil {
+				return err
+			}
+			companions = append(companions, *companion)
+			return nil
+		}
+		catsrc, err := toCatsrc(obj)
+		if err != nil {
+			return err
+		}
+		catsrcs = append(catsrcs, *catsrc)
+		return nil
+	}
+
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// Skip empty documents
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if !obj.IsList() {
+			if err := decodeObject(obj); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if kind := obj.GetKind(); kind != "CatalogSourceList" && kind != "List" {
+			return nil, nil, fmt.Errorf("unsupported list kind %q", kind)
+		}
+		err = obj.EachListItem(func(item runtime.Object) error {
+			u := item.(*unstructured.Unstructured)
+			if u.GetKind() == "" {
+				u.SetKind("CatalogSource")
+			}
+			return decodeObject(u)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return catsrcs, companions, nil
+}
+
+// toCatsrc converts the given object into a CatalogSource
+func toCatsrc(obj *unstructured.Unstructured) (*olmv1alpha1.CatalogSource, error) {
+	if obj.GetKind() != "CatalogSource" {
+		return nil, fmt.Errorf("unsupported kind %q, only CatalogSources and their companion %s objects are supported", obj.GetKind(), companionKindNames())
 	}
 
 	catsrc := &olmv1alpha1.CatalogSource{}
-	decoder := yaml.NewYAMLOrJSONDecoder(file, 1024)
-	err = decoder.Decode(catsrc)
-	if err != nil {
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, catsrc); err != nil {
 		return nil, err
 	}
-	if strings.Compare(catsrc.Kind, "CatalogSource") != 0 {
-		return nil, errors.New("Not an CatalogSource")
-	}
 	return catsrc, nil
 }
 
-// processCatsrc will ensure that the given CatalogSource is present or not on
-// the cluster based on the disable flag.
-func processCatsrc(ctx context.Context, client wrapper.Client, def olmv1alpha1.CatalogSource, disable bool) error {
+// validateCatsrcDefinition returns an error if the given CatalogSource
+// definition is missing the fields required to reconcile it on the cluster.
+func validateCatsrcDefinition(catsrc *olmv1alpha1.CatalogSource) error {
+	if catsrc.Name == "" {
+		return errors.New("CatalogSource definition has no name")
+	}
+	if catsrc.Namespace == "" {
+		return fmt.Errorf("CatalogSource %s has no namespace", catsrc.Name)
+	}
+	return nil
+}
+
+// processCatsrc will ensure that the given CatalogSource and its companion
+// objects are present or not on the cluster based on the disable flag. In
+// dry-run mode nothing is changed on the cluster, the action that would have
+// been taken is returned and reported instead.
+func processCatsrc(ctx context.Context, client wrapper.Client, recorder *eventRecorder, def olmv1alpha1.CatalogSource, companions []unstructured.Unstructured, disable, dryRun bool) (Action, error) {
 	// Get CatalogSource on the cluster
 	cluster := &olmv1alpha1.CatalogSource{}
 	if err := client.Get(ctx, wrapper.ObjectKey{
@@ -65,88 +156,264 @@ func processCatsrc(ctx context.Context, client wrapper.Client, def olmv1alpha1.C
 		Namespace: def.Namespace,
 	}, cluster); err != nil && !k8sErrors.IsNotFound(err) {
 		logrus.Errorf("[defaults] Error getting CatalogSource %s - %v", def.Name, err)
-		return err
+		return ActionNone, err
+	}
+
+	// Neither the CatalogSource nor its companion objects are enforced while
+	// it is unmanaged
+	if cluster.Name != "" && isUnmanaged(cluster) {
+		logrus.Infof("[defaults] CatalogSource %s is annotated with %s=true, leaving it as it is", def.Name, UnmanagedAnnotationKey)
+		recorder.warningOnChange(cluster, UnmanagedReason, "Reconcile", "The CatalogSource is annotated with %s=true and is not managed by the operator", UnmanagedAnnotationKey)
+		return ActionUnmanaged, nil
 	}
 
+	var action Action
 	var err error
 	if disable {
 		if cluster.Annotations[defaultCatsrcAnnotationKey] == defaultCatsrcAnnotationValue {
-			err = ensureCatsrcAbsent(ctx, client, def, cluster)
+			action, err = ensureCatsrcAbsent(ctx, client, recorder, def, cluster, dryRun)
+		} else if cluster.Name != "" {
+			action = ActionConflict
+			logrus.Infof("[defaults] CatalogSource %s is disabled but is not annotated as managed by the operator, leaving it in place", def.Name)
+			recorder.warningOnChange(cluster, SkippedReason, "Delete", "The CatalogSource is disabled but is left in place as it is not annotated as managed by the operator")
+		}
+		if err == nil {
+			err = ensureCompanionsAbsent(ctx, client, def.Name, companions, dryRun)
 		}
 	} else {
-		err = ensureCatsrcPresent(ctx, client, def, cluster)
+		// Companion objects are ensured first as the CatalogSource pod may
+		// depend on them
+		err = ensureCompanionsPresent(ctx, client, def.Name, companions, dryRun)
+		if err == nil {
+			action, err = ensureCatsrcPresent(ctx, client, recorder, def, cluster, dryRun)
+		}
 	}
 
-	if err != nil {
+	var conflict *fieldConflictError
+	if errors.As(err, &conflict) {
+		logrus.Warnf("[defaults] Not enforcing CatalogSource %s - %v", def.Name, err)
+		if cluster.Name != "" {
+			recorder.warningOnChange(cluster, FieldConflictReason, "Apply", "The default definition is not enforced: %v", err)
+		}
+	} else if err != nil {
 		logrus.Errorf("[defaults] Error processing CatalogSource %s - %v", def.Name, err)
 	}
 
-	return err
+	return action, err
 }
 
 // ensureCatsrcAbsent ensure that that the default CatalogSource is not present on the cluster
 func ensureCatsrcAbsent(
 	ctx context.Context,
 	client wrapper.Client,
+	recorder *eventRecorder,
 	def olmv1alpha1.CatalogSource,
 	cluster *olmv1alpha1.CatalogSource,
-) error {
+	dryRun bool,
+) (Action, error) {
 	// CatalogSource is not present on the cluster or has been marked for deletion
 	if cluster.Name == "" || !cluster.ObjectMeta.DeletionTimestamp.IsZero() {
 		logrus.Infof("[defaults] CatalogSource %s not present or has been marked for deletion", def.Name)
-		return nil
+		return ActionNone, nil
 	}
 
+	if dryRun {
+		reportDryRun(recorder, cluster, ActionDelete, "delete the CatalogSource as it is disabled in the OperatorHub configuration")
+		return ActionDelete, nil
+	}
 	if err := client.Delete(ctx, cluster); err != nil {
-		return err
+		return ActionNone, err
 	}
 	logrus.Infof("[defaults] Deleting CatalogSource %s", def.Name)
+	recorder.normal(cluster, DeletedReason, "Delete", "Deleted the CatalogSource as it is disabled in the OperatorHub configuration")
 
-	return nil
+	return ActionDelete, nil
 }
 
-// ensureCatsrcPresent ensure that that the default CatalogSource is present on the cluster
+// ensureCatsrcPresent ensure that that the default CatalogSource is present on the cluster.
+// The CatalogSource is applied with server-side apply under FieldManager, so
+// only the fields set in the definition are owned and enforced.
 func ensureCatsrcPresent(
 	ctx context.Context,
 	client wrapper.Client,
+	recorder *eventRecorder,
 	def olmv1alpha1.CatalogSource,
 	cluster *olmv1alpha1.CatalogSource,
-) error {
+	dryRun bool,
+) (Action, error) {
 	def = *def.DeepCopy()
 	if def.Annotations == nil {
 		def.Annotations = make(map[string]string)
 	}
 	def.Annotations[defaultCatsrcAnnotationKey] = defaultCatsrcAnnotationValue
 
-	// Create if not present or is deleted
-	if cluster.Name == "" || (!cluster.ObjectMeta.DeletionTimestamp.IsZero() && len(cluster.Finalizers) == 0) {
-		err := client.Create(ctx, &def)
+	// Rewrite the image before comparing so that the mirrored image is
+	// considered the desired state
+	if MirrorImages {
+		if err := mirrorImage(ctx, client, &def); err != nil {
+			return ActionNone, err
+		}
+	}
+
+	desired, err := toApplyConfiguration(&def)
+	if err != nil {
+		return ActionNone, err
+	}
+
+	// Create if not present
+	if cluster.Name == "" {
+		if dryRun {
+			reportDryRun(recorder, &def, ActionCreate, "create the default CatalogSource")
+			return ActionCreate, nil
+		}
+		created, err := applyCatsrc(ctx, client, desired, false)
 		if err != nil {
-			return err
+			return ActionNone, err
 		}
 		logrus.Infof("[defaults] Creating CatalogSource %s", def.Name)
-		return nil
+		recorder.normal(created, CreatedReason, "Create", "Created the default CatalogSource")
+		return ActionCreate, nil
 	}
 
-	if cluster.Annotations[defaultCatsrcAnnotationKey] == defaultCatsrcAnnotationValue && AreCatsrcSpecsEqual(&def.Spec, &cluster.Spec) {
-		logrus.Infof("[defaults] CatalogSource %s is annotated and its spec is the same as the default spec", def.Name)
-		return nil
+	// Applying to a CatalogSource that is being deleted would not stop the
+	// deletion, it is recreated once it is gone
+	if !cluster.ObjectMeta.DeletionTimestamp.IsZero() {
+		return waitForDeletion(recorder, cluster), nil
 	}
 
-	// Update if the spec has changed
-	cluster.Spec = def.Spec
-	if cluster.Annotations == nil {
-		cluster.Annotations = make(map[string]string)
+	// A CatalogSource with the same name that was not created by the
+	// operator is only taken over according to the adoption policy
+	if cluster.Annotations[defaultCatsrcAnnotationKey] != defaultCatsrcAnnotationValue {
+		return adoptCatsrc(ctx, client, recorder, &def, desired, cluster, dryRun)
+	}
+
+	inSync, err := isCatsrcInSync(desired, cluster)
+	if err != nil {
+		return ActionNone, err
+	}
+	if inSync {
+		logrus.Infof("[defaults] CatalogSource %s is annotated and its spec is the same as the default spec", def.Name)
+		return ActionNone, nil
 	}
-	cluster.Annotations[defaultCatsrcAnnotationKey] = defaultCatsrcAnnotationValue
-	err := client.Update(ctx, cluster)
+
+	// The drift is computed before applying, as the managed fields of the
+	// other writers are taken over by the apply
+	drift, err := computeDrift(desired, cluster)
 	if err != nil {
+		return ActionNone, err
+	}
+	restored := make([]string, 0, len(drift))
+	for _, field := range drift {
+		restored = append(restored, field.String())
+	}
+
+	if dryRun {
+		if len(drift) == 0 {
+			reportDryRun(recorder, cluster, ActionUpdate, "update the CatalogSource to its default definition")
+			return ActionUpdate, nil
+		}
+		reportDryRun(recorder, cluster, ActionRestore, "restore fields changed on the cluster: %s", strings.Join(restored, "; "))
+		return ActionRestore, nil
+	}
+
+	// Restoring a CatalogSource that another field manager keeps changing is
+	// backed off, so that the two do not fight in a tight loop
+	if backoff, writers := contested(def.Name); len(drift) > 0 && backoff > 0 {
+		logrus.Infof("[defaults] Not restoring CatalogSource %s for another %s as it keeps being changed by %s", def.Name, backoff.Round(time.Second), strings.Join(writers, ", "))
+		return ActionContested, nil
+	}
+
+	// Fields that were changed by someone else are only taken over according
+	// to the conflict policy
+	err = applyWithPolicy("CatalogSource "+def.Name, "", func(force bool) error {
+		_, err := applyCatsrc(ctx, client, desired, force)
 		return err
+	})
+	if err != nil {
+		return ActionNone, err
 	}
 
-	logrus.Infof("[defaults] Restoring CatalogSource %s", def.Name)
+	if len(drift) == 0 {
+		logrus.Infof("[defaults] Updating CatalogSource %s to its default definition", def.Name)
+		recorder.normal(cluster, UpdatedReason, "Update", "Updated the CatalogSource to its default definition")
+		return ActionUpdate, nil
+	}
 
-	return nil
+	for _, field := range drift {
+		metrics.DefaultCatalogRestores.WithLabelValues(def.Name, field.Path()).Inc()
+	}
+	logrus.Infof("[defaults] Restoring CatalogSource %s - changed fields: %s", def.Name, strings.Join(restored, "; "))
+	recorder.warning(cluster, RestoredReason, "Restore", "Restored fields changed on the cluster: %s", strings.Join(restored, "; "))
+	if backoff, restores, writers := recordRestore(def.Name, drift); backoff > 0 {
+		logrus.Warnf("[defaults] CatalogSource %s was restored %d times within %s as it keeps being changed by %s, backing off restoring it for %s", def.Name, restores, FlappingWindow, strings.Join(writers, ", "), backoff)
+		recorder.warning(cluster, ContestedReason, "Restore", "The CatalogSource was restored %d times within %s as it keeps being changed by %s, restoring it is backed off for %s", restores, FlappingWindow, strings.Join(writers, ", "), backoff)
+	}
+
+	return ActionRestore, nil
+}
+
+// toApplyConfiguration returns the object the given CatalogSource definition
+// is applied with. It only holds the fields set in the definition.
+func toApplyConfiguration(def *olmv1alpha1.CatalogSource) (*unstructured.Unstructured, error) {
+	spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&def.Spec)
+	if err != nil {
+		return nil, err
+	}
+	// The icon is not omitted when empty
+	if icon, ok := spec["icon"].(map[string]interface{}); ok && icon["base64data"] == "" && icon["mediatype"] == "" {
+		delete(spec, "icon")
+	}
+
+	desired := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	desired.SetGroupVersionKind(olmv1alpha1.SchemeGroupVersion.WithKind(olmv1alpha1.CatalogSourceKind))
+	desired.SetName(def.Name)
+	desired.SetNamespace(def.Namespace)
+	desired.SetLabels(def.Labels)
+	desired.SetAnnotations(def.Annotations)
+	return desired, nil
+}
+
+// isCatsrcInSync returns true if all the fields of the desired CatalogSource
+// are set on the cluster and no annotation that is no longer desired is left
+// behind.
+func isCatsrcInSync(desired *unstructured.Unstructured, cluster *olmv1alpha1.CatalogSource) (bool, error) {
+	for _, key := range []string{OriginalImageAnnotationKey, OverriddenFieldsAnnotationKey} {
+		if _, stale := cluster.Annotations[key]; stale {
+			if _, wanted := desired.GetAnnotations()[key]; !wanted {
+				return false, nil
+			}
+		}
+	}
+
+	current, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cluster)
+	if err != nil {
+		return false, err
+	}
+	// The type meta is not set on objects read through the client
+	return isSubset(desired.Object["metadata"], current["metadata"]) && isSubset(lowerSpecFields(desired.Object["spec"]), lowerSpecFields(current["spec"])), nil
+}
+
+// caseInsensitiveSpecFields are the fields of a CatalogSource spec that are
+// compared regardless of case when checking whether it drifted
+var caseInsensitiveSpecFields = []string{"sourceType", "configMap", "address", "displayName", "publisher", "image"}
+
+// lowerSpecFields returns a copy of the CatalogSource spec with the fields
+// in caseInsensitiveSpecFields lower cased
+func lowerSpecFields(spec interface{}) interface{} {
+	fields, ok := spec.(map[string]interface{})
+	if !ok {
+		return spec
+	}
+	lowered := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		lowered[key] = value
+	}
+	for _, key := range caseInsensitiveSpecFields {
+		if value, ok := lowered[key].(string); ok {
+			lowered[key] = strings.ToLower(value)
+		}
+	}
+	return lowered
 }
 
 // AreCatsrcSpecsEqual returns true if the Specs it receives are the same.
@@ -183,3 +450,21 @@ func AreCatsrcSpecsEqual(spec1 *olmv1alpha1.CatalogSourceSpec, spec2 *olmv1alpha
 
 	return reflect.DeepEqual(spec1Copy, spec2Copy)
 }
+
+// applyCatsrc applies the desired CatalogSource with server-side apply and
+// returns the CatalogSource returned by the API server
+func applyCatsrc(ctx context.Context, client wrapper.Client, desired *unstructured.Unstructured, force bool) (*olmv1alpha1.CatalogSource, error) {
+	opts := []crclient.ApplyOption{crclient.FieldOwner(FieldManager)}
+	if force {
+		opts = append(opts, crclient.ForceOwnership)
+	}
+	applied := desired.DeepCopy()
+	if err := client.Apply(ctx, crclient.ApplyConfigurationFromUnstructured(applied), opts...); err != nil {
+		return nil, err
+	}
+	catsrc := &olmv1alpha1.CatalogSource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(applied.Object, catsrc); err != nil {
+		return nil, err
+	}
+	return catsrc, nil
+}
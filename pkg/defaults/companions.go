@@ -0,0 +1,268 @@
+package defaults
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CompanionLabelKey is the label that groups a companion object with the
+// default CatalogSource it is named after. Companion objects are created,
+// restored and deleted together with their CatalogSource.
+const CompanionLabelKey string = "operatorframework.io/default-catalogsource"
+
+// companionKinds are the kinds that may be defined alongside the default
+// CatalogSources.
+var companionKinds = map[schema.GroupKind]bool{
+	{Kind: "ConfigMap"}: true,
+	{Kind: "Secret"}:    true,
+	{Group: "networking.k8s.io", Kind: "NetworkPolicy"}: true,
+}
+
+// isCompanionKind returns true if the given object is of one of the
+// companionKinds
+func isCompanionKind(obj *unstructured.Unstructured) bool {
+	return companionKinds[obj.GroupVersionKind().GroupKind()]
+}
+
+// companionKindNames returns the sorted names of the companionKinds
+func companionKindNames() string {
+	names := make([]string, 0, len(companionKinds))
+	for gk := range companionKinds {
+		names = append(names, gk.Kind)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// toCompanion normalizes the given companion object so that it can be
+// compared with the objects read from the cluster.
+func toCompanion(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	// Round trip through JSON so that numbers are decoded the same way as
+	// objects read from the cluster
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	companion := &unstructured.Unstructured{}
+	if err := companion.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+
+	// stringData is write-only, so it is folded into data to be comparable
+	if companion.GetKind() == "Secret" {
+		stringData, _, err := unstructured.NestedStringMap(companion.Object, "stringData")
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range stringData {
+			if err := unstructured.SetNestedField(companion.Object, base64.StdEncoding.EncodeToString([]byte(value)), "data", key); err != nil {
+				return nil, err
+			}
+		}
+		unstructured.RemoveNestedField(companion.Object, "stringData")
+	}
+	return companion, nil
+}
+
+// groupCompanions validates the companion objects loaded alongside the given
+// CatalogSources and groups them by the name of their CatalogSource. Each
+// companion must name a CatalogSource defined in the same file through the
+// CompanionLabelKey label and is placed in the namespace of that
+// CatalogSource.
+func groupCompanions(catsrcs []olmv1alpha1.CatalogSource, companions []unstructured.Unstructured) (map[string][]unstructured.Unstructured, error) {
+	namespaces := make(map[string]string)
+	for _, catsrc := range catsrcs {
+		namespaces[catsrc.Name] = catsrc.Namespace
+	}
+
+	groups := make(map[string][]unstructured.Unstructured)
+	seen := make(map[string]bool)
+	for i := range companions {
+		companion := &companions[i]
+		kind, name := companion.GetKind(), companion.GetName()
+		if name == "" {
+			return nil, fmt.Errorf("%s definition has no name", kind)
+		}
+
+		catsrcName := companion.GetLabels()[CompanionLabelKey]
+		if catsrcName == "" {
+			return nil, fmt.Errorf("%s %s has no %s label naming its CatalogSource", kind, name, CompanionLabelKey)
+		}
+		namespace, present := namespaces[catsrcName]
+		if !present {
+			return nil, fmt.Errorf("%s %s belongs to CatalogSource %s which is not defined in the same file", kind, name, catsrcName)
+		}
+
+		if companion.GetNamespace() == "" {
+			companion.SetNamespace(namespace)
+		} else if companion.GetNamespace() != namespace {
+			return nil, fmt.Errorf("%s %s is in namespace %s but its CatalogSource %s is in namespace %s", kind, name, companion.GetNamespace(), catsrcName, namespace)
+		}
+
+		key := companion.GroupVersionKind().GroupKind().String() + "/" + name
+		if seen[key] {
+			return nil, fmt.Errorf("%s %s is defined more than once in the same file", kind, name)
+		}
+		seen[key] = true
+
+		groups[catsrcName] = append(groups[catsrcName], *companion)
+	}
+	return groups, nil
+}
+
+// ensureCompanionsPresent ensures that the given companion objects are
+// present on the cluster and match their definitions
+func ensureCompanionsPresent(ctx context.Context, client wrapper.Client, catsrcName string, companions []unstructured.Unstructured, dryRun bool) error {
+	for _, def := range companions {
+		if err := ensureCompanionPresent(ctx, client, catsrcName, def, dryRun); err != nil {
+			return fmt.Errorf("failed to ensure %s %s: %w", def.GetKind(), def.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// ensureCompanionPresent ensures that the given companion object is present
+// on the cluster. It is applied with server-side apply under FieldManager, so
+// only the fields set in the definition are owned and compared, and the
+// fields added by others are kept. An object with the same name that is not
+// annotated as managed by the operator is left alone.
+func ensureCompanionPresent(ctx context.Context, client wrapper.Client, catsrcName string, def unstructured.Unstructured, dryRun bool) error {
+	def = *def.DeepCopy()
+	annotations := def.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[defaultCatsrcAnnotationKey] = defaultCatsrcAnnotationValue
+	def.SetAnnotations(annotations)
+
+	cluster := &unstructured.Unstructured{}
+	cluster.SetGroupVersionKind(def.GroupVersionKind())
+	err := client.Get(ctx, wrapper.ObjectKey{Name: def.GetName(), Namespace: def.GetNamespace()}, cluster)
+	if k8sErrors.IsNotFound(err) {
+		if dryRun {
+			logrus.Infof("[defaults] Dry run: would create %s %s for CatalogSource %s", def.GetKind(), def.GetName(), catsrcName)
+			return nil
+		}
+		if err := applyCompanion(ctx, client, &def, false); err != nil {
+			return err
+		}
+		logrus.Infof("[defaults] Creating %s %s for CatalogSource %s", def.GetKind(), def.GetName(), catsrcName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if cluster.GetAnnotations()[defaultCatsrcAnnotationKey] != defaultCatsrcAnnotationValue {
+		logrus.Warnf("[defaults] %s %s for CatalogSource %s is not annotated as managed by the operator, leaving it as it is", def.GetKind(), def.GetName(), catsrcName)
+		return nil
+	}
+
+	// The object will be recreated once it is gone
+	if cluster.GetDeletionTimestamp() != nil {
+		logrus.Infof("[defaults] %s %s for CatalogSource %s has been marked for deletion", def.GetKind(), def.GetName(), catsrcName)
+		return nil
+	}
+
+	if isSubset(def.Object, cluster.Object) {
+		return nil
+	}
+	if dryRun {
+		logrus.Infof("[defaults] Dry run: would restore %s %s for CatalogSource %s", def.GetKind(), def.GetName(), catsrcName)
+		return nil
+	}
+
+	object := fmt.Sprintf("%s %s", def.GetKind(), def.GetName())
+	err = applyWithPolicy(object, object+" ", func(force bool) error {
+		return applyCompanion(ctx, client, &def, force)
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("[defaults] Restoring %s %s for CatalogSource %s", def.GetKind(), def.GetName(), catsrcName)
+	return nil
+}
+
+// applyCompanion applies the given companion object with server-side apply
+func applyCompanion(ctx context.Context, client wrapper.Client, def *unstructured.Unstructured, force bool) error {
+	opts := []crclient.ApplyOption{crclient.FieldOwner(FieldManager)}
+	if force {
+		opts = append(opts, crclient.ForceOwnership)
+	}
+	return client.Apply(ctx, crclient.ApplyConfigurationFromUnstructured(def.DeepCopy()), opts...)
+}
+
+// ensureCompanionsAbsent deletes the given companion objects from the
+// cluster. Objects that are not managed by the operator are left untouched.
+func ensureCompanionsAbsent(ctx context.Context, client wrapper.Client, catsrcName string, companions []unstructured.Unstructured, dryRun bool) error {
+	for _, def := range companions {
+		cluster := &unstructured.Unstructured{}
+		cluster.SetGroupVersionKind(def.GroupVersionKind())
+		err := client.Get(ctx, wrapper.ObjectKey{Name: def.GetName(), Namespace: def.GetNamespace()}, cluster)
+		if k8sErrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get %s %s: %w", def.GetKind(), def.GetName(), err)
+		}
+		if cluster.GetAnnotations()[defaultCatsrcAnnotationKey] != defaultCatsrcAnnotationValue || cluster.GetDeletionTimestamp() != nil {
+			continue
+		}
+		if dryRun {
+			logrus.Infof("[defaults] Dry run: would delete %s %s for CatalogSource %s", def.GetKind(), def.GetName(), catsrcName)
+			continue
+		}
+
+		if err := client.Delete(ctx, cluster); err != nil && !k8sErrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s: %w", def.GetKind(), def.GetName(), err)
+		}
+		logrus.Infof("[defaults] Deleting %s %s for CatalogSource %s", def.GetKind(), def.GetName(), catsrcName)
+	}
+	return nil
+}
+
+// isSubset returns true if every field set in desired is set to the same
+// value in actual. Lists must have the same length and each of their items
+// must be a subset of the corresponding item.
+func isSubset(desired, actual interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range d {
+			actualValue, present := a[key]
+			if !present || !isSubset(value, actualValue) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(d) {
+			return false
+		}
+		for i := range d {
+			if !isSubset(d[i], a[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(desired, actual)
+	}
+}
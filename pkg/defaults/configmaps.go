@@ -0,0 +1,155 @@
+package defaults
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// ConfigMapLabelKey is the label that marks a ConfigMap in the operator's
+	// namespace as a source of default CatalogSource definitions. Every key
+	// of such a ConfigMap with a manifest extension is loaded the same way as
+	// a file in the defaults directory.
+	ConfigMapLabelKey   string = "operatorframework.io/default-catalogsources"
+	ConfigMapLabelValue string = "true"
+)
+
+// SetConfigMapDefinitions replaces the definitions loaded from defaults
+// ConfigMaps with the ones found in the given ConfigMaps and merges them into
+// the global definitions. ConfigMaps are processed in name order, so if two of
+// them define the same CatalogSource the one with the lowest name wins. A
+// definition that omits its namespace is placed in the ConfigMap's namespace.
+// It returns true if the definitions or the load errors changed.
+func SetConfigMapDefinitions(configMaps []corev1.ConfigMap) bool {
+	globalsLock.RLock()
+	templateContext := globalTemplateContext
+	globalsLock.RUnlock()
+
+	set := populateConfigMapDefs(configMaps, templateContext)
+
+	globalsLock.Lock()
+	defer globalsLock.Unlock()
+	configMapDefinitionsLoaded = true
+	if set.equal(configMapDefinitions) {
+		return false
+	}
+	configMapDefinitions = set
+	mergeGlobals()
+	return true
+}
+
+// ExpectConfigMapDefinitions records that definitions are loaded from the
+// defaults ConfigMaps. Orphaned CatalogSources are not deleted until they have
+// been loaded once, as the definitions of the orphans may be among them.
+func ExpectConfigMapDefinitions() {
+	globalsLock.Lock()
+	defer globalsLock.Unlock()
+	configMapDefinitionsExpected = true
+}
+
+// populateConfigMapDefs returns the CatalogSource definitions found in the
+// given ConfigMaps. A key that fails to load is skipped as a whole and
+// reported in the load errors of the returned set.
+func populateConfigMapDefs(configMaps []corev1.ConfigMap, templateContext TemplateContext) definitionSet {
+	set := newDefinitionSet()
+
+	sorted := make([]corev1.ConfigMap, len(configMaps))
+	copy(sorted, configMaps)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for _, configMap := range sorted {
+		keys := make([]string, 0, len(configMap.Data))
+		for key := range configMap.Data {
+			if isManifestFile(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			origin := fmt.Sprintf("configmaps/%s/%s", configMap.Name, key)
+			var catsrcs []olmv1alpha1.CatalogSource
+			var companions []unstructured.Unstructured
+			content, err := renderDefinitions(origin, []byte(configMap.Data[key]), templateContext)
+			if err == nil {
+				catsrcs, companions, err = decodeDefinitions(bytes.NewReader(content))
+			}
+			if err == nil {
+				err = validateConfigMapDefinitions(catsrcs, companions)
+			}
+			if err == nil {
+				err = setConfigMapNamespace(catsrcs, configMap.Namespace)
+			}
+			if err == nil {
+				err = set.add(origin, catsrcs, companions, templateContext)
+			}
+			if err != nil {
+				set.loadErrors = append(set.loadErrors, LoadError{File: origin, Err: err})
+			}
+		}
+	}
+	return set
+}
+
+// setConfigMapNamespace places the CatalogSources that omit their namespace
+// in the given namespace. CatalogSources from a ConfigMap may not target any
+// other namespace.
+func setConfigMapNamespace(catsrcs []olmv1alpha1.CatalogSource, namespace string) error {
+	for i := range catsrcs {
+		if catsrcs[i].Namespace == "" {
+			catsrcs[i].Namespace = namespace
+		}
+		if catsrcs[i].Namespace != namespace {
+			return fmt.Errorf("CatalogSource %s must be in the %s namespace of its ConfigMap, not %s", catsrcs[i].Name, namespace, catsrcs[i].Namespace)
+		}
+	}
+	return nil
+}
+
+// validateConfigMapDefinitions rejects the definitions that need more trust
+// than writing a ConfigMap in the operator's namespace grants. Companion
+// objects, which may be Secrets and NetworkPolicies, and scheduling catalog
+// pods on the master nodes are reserved to the defaults directory, which is
+// part of the operator's deployment.
+func validateConfigMapDefinitions(catsrcs []olmv1alpha1.CatalogSource, companions []unstructured.Unstructured) error {
+	if len(companions) > 0 {
+		return fmt.Errorf("companion %s %s can only be defined in the defaults directory", companions[0].GetKind(), companions[0].GetName())
+	}
+	for _, catsrc := range catsrcs {
+		if catsrc.Spec.GrpcPodConfig == nil {
+			continue
+		}
+		for _, key := range masterNodeSelectorKeys {
+			if _, present := catsrc.Spec.GrpcPodConfig.NodeSelector[key]; present {
+				return fmt.Errorf("CatalogSource %s cannot select the master nodes with the %s nodeSelector outside of the defaults directory", catsrc.Name, key)
+			}
+		}
+		for _, toleration := range catsrc.Spec.GrpcPodConfig.Tolerations {
+			if toleratesMasters(toleration) {
+				return fmt.Errorf("CatalogSource %s cannot tolerate the taints of the master nodes outside of the defaults directory", catsrc.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// toleratesMasters returns true if the toleration tolerates the taints of
+// the master nodes, including a toleration of every taint
+func toleratesMasters(toleration corev1.Toleration) bool {
+	if toleration.Key == "" {
+		return toleration.Operator == corev1.TolerationOpExists
+	}
+	for _, key := range masterNodeSelectorKeys {
+		if toleration.Key == key {
+			return true
+		}
+	}
+	return false
+}
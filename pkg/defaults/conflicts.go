@@ -0,0 +1,91 @@
+package defaults
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConflictPolicy decides whether fields of a default CatalogSource or of its
+// companion objects that another field manager took over are taken back
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyReport leaves the conflicting fields as they are and
+	// reports the conflict
+	ConflictPolicyReport ConflictPolicy = "report"
+	// ConflictPolicyForce takes back the conflicting fields. Restoring a
+	// CatalogSource that keeps being changed is still backed off.
+	ConflictPolicyForce ConflictPolicy = "force"
+)
+
+// DefaultsConflictPolicy is the policy applied when applying a default
+// CatalogSource or a companion object conflicts with another field manager
+var DefaultsConflictPolicy = ConflictPolicyForce
+
+// String implements flag.Value
+func (p *ConflictPolicy) String() string {
+	return string(*p)
+}
+
+// Set implements flag.Value
+func (p *ConflictPolicy) Set(value string) error {
+	switch ConflictPolicy(value) {
+	case ConflictPolicyReport, ConflictPolicyForce:
+		*p = ConflictPolicy(value)
+		return nil
+	}
+	return fmt.Errorf("unsupported policy %q, it must be either %s or %s", value, ConflictPolicyReport, ConflictPolicyForce)
+}
+
+// applyConflicts returns the fields of a server-side apply that conflict with
+// other field managers, along with who they are owned by. It returns nil if
+// the error is not an apply conflict.
+func applyConflicts(err error) []string {
+	var status k8sErrors.APIStatus
+	if !k8sErrors.IsConflict(err) || !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+	var conflicts []string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", cause.Field, cause.Message))
+		}
+	}
+	return conflicts
+}
+
+// fieldConflictError is returned when applying an object conflicts with other
+// field managers and DefaultsConflictPolicy does not take the conflicting
+// fields over
+type fieldConflictError struct {
+	conflicts []string
+}
+
+func (e *fieldConflictError) Error() string {
+	return fmt.Sprintf("fields conflict with other field managers and are not taken over as the conflict policy is %s: %s", ConflictPolicyReport, strings.Join(e.conflicts, "; "))
+}
+
+// applyWithPolicy calls apply without forcing the ownership of the fields,
+// then again forcing it if the apply conflicts with other field managers and
+// DefaultsConflictPolicy allows it. Otherwise the conflict is returned as a
+// fieldConflictError, with the conflicting fields prefixed with prefix.
+func applyWithPolicy(object, prefix string, apply func(force bool) error) error {
+	err := apply(false)
+	conflicts := applyConflicts(err)
+	if len(conflicts) == 0 {
+		return err
+	}
+	if DefaultsConflictPolicy != ConflictPolicyForce {
+		for i := range conflicts {
+			conflicts[i] = prefix + conflicts[i]
+		}
+		return &fieldConflictError{conflicts: conflicts}
+	}
+	logrus.Warnf("[defaults] Taking ownership of fields of %s that conflict with other field managers: %s", object, strings.Join(conflicts, "; "))
+	return apply(true)
+}
@@ -0,0 +1,67 @@
+package defaults
+
+import (
+	"fmt"
+
+	semver "github.com/blang/semver/v4"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+)
+
+const (
+	// MinClusterVersionAnnotationKey and MaxClusterVersionAnnotationKey are
+	// the annotations that restrict a default definition to a range of
+	// cluster versions. Both bounds are inclusive and only the major and
+	// minor parts of the versions are compared.
+	MinClusterVersionAnnotationKey string = "operatorframework.io/minClusterVersion"
+	MaxClusterVersionAnnotationKey string = "operatorframework.io/maxClusterVersion"
+)
+
+// checkClusterVersion returns a non-empty reason if the given CatalogSource
+// does not apply to a cluster at releaseVersion because of its cluster
+// version annotations. Every definition applies if the release version is
+// unknown.
+func checkClusterVersion(catsrc *olmv1alpha1.CatalogSource, releaseVersion *semver.Version) (string, error) {
+	minVersion, err := parseClusterVersionAnnotation(catsrc, MinClusterVersionAnnotationKey)
+	if err != nil {
+		return "", err
+	}
+	maxVersion, err := parseClusterVersionAnnotation(catsrc, MaxClusterVersionAnnotationKey)
+	if err != nil {
+		return "", err
+	}
+	if minVersion != nil && maxVersion != nil && compareMinor(*minVersion, *maxVersion) > 0 {
+		return "", fmt.Errorf("CatalogSource %s has a %s greater than its %s", catsrc.Name, MinClusterVersionAnnotationKey, MaxClusterVersionAnnotationKey)
+	}
+
+	if releaseVersion == nil {
+		return "", nil
+	}
+	if minVersion != nil && compareMinor(*releaseVersion, *minVersion) < 0 {
+		return fmt.Sprintf("Requires a cluster version of at least %d.%d, the cluster is at %s", minVersion.Major, minVersion.Minor, releaseVersion), nil
+	}
+	if maxVersion != nil && compareMinor(*releaseVersion, *maxVersion) > 0 {
+		return fmt.Sprintf("Requires a cluster version of at most %d.%d, the cluster is at %s", maxVersion.Major, maxVersion.Minor, releaseVersion), nil
+	}
+	return "", nil
+}
+
+// parseClusterVersionAnnotation parses the version in the given annotation of
+// the CatalogSource. It returns nil if the annotation is not set.
+func parseClusterVersionAnnotation(catsrc *olmv1alpha1.CatalogSource, key string) (*semver.Version, error) {
+	versionString, present := catsrc.Annotations[key]
+	if !present {
+		return nil, nil
+	}
+
+	// Tolerate versions without a patch part, such as 4.20
+	v, err := semver.ParseTolerant(versionString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q for CatalogSource %s: %w", key, versionString, catsrc.Name, err)
+	}
+	return &v, nil
+}
+
+// compareMinor compares the major and minor parts of the given versions
+func compareMinor(a, b semver.Version) int {
+	return semver.Version{Major: a.Major, Minor: a.Minor}.Compare(semver.Version{Major: b.Major, Minor: b.Minor})
+}
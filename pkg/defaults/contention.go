@@ -0,0 +1,169 @@
+package defaults
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/operator-framework/operator-marketplace/pkg/status"
+)
+
+// ContestedSourcesReason is the ClusterOperator Degraded reason reported while
+// restoring some default CatalogSources is backed off because another field
+// manager keeps changing them.
+const ContestedSourcesReason string = "DefaultCatalogSourcesContested"
+
+var (
+	// FlappingWindow is the sliding window the restores of each default
+	// CatalogSource are counted over
+	FlappingWindow = 10 * time.Minute
+
+	// FlappingThreshold is the number of restores within FlappingWindow after
+	// which a default CatalogSource is considered contested
+	FlappingThreshold = 5
+
+	// FlappingBackoff is how long restoring a contested CatalogSource is
+	// backed off the first time. It doubles every time the CatalogSource is
+	// contested again, up to MaxFlappingBackoff.
+	FlappingBackoff = time.Minute
+
+	// MaxFlappingBackoff is the longest restoring a contested CatalogSource is
+	// backed off
+	MaxFlappingBackoff = 30 * time.Minute
+
+	// contentions holds the recent restores of each default CatalogSource
+	contentions    = make(map[string]*contention)
+	contentionLock sync.Mutex
+)
+
+// contention tracks the restores of a default CatalogSource
+type contention struct {
+	// restores are the times the CatalogSource was restored within
+	// FlappingWindow
+	restores []time.Time
+	// writers are the field managers whose changes were restored
+	writers []string
+	// backoffs is the number of times restoring was backed off since the
+	// CatalogSource was last left alone
+	backoffs int
+	// until is when restoring the CatalogSource resumes
+	until time.Time
+}
+
+// prune drops the restores that are out of the window
+func (c *contention) prune(now time.Time) {
+	i := 0
+	for i < len(c.restores) && now.Sub(c.restores[i]) > FlappingWindow {
+		i++
+	}
+	c.restores = c.restores[i:]
+}
+
+// contested returns how long restoring the given CatalogSource is still backed
+// off for and the field managers that keep changing it
+func contested(sourceName string) (time.Duration, []string) {
+	contentionLock.Lock()
+	defer contentionLock.Unlock()
+
+	c, present := contentions[sourceName]
+	if !present {
+		return 0, nil
+	}
+	return time.Until(c.until), c.writers
+}
+
+// recordRestore records a restore of the given CatalogSource that undid the
+// changes of the given field managers. Once FlappingThreshold restores are
+// within FlappingWindow, it backs off restoring the CatalogSource and returns
+// for how long, along with the restore count and all the competing writers.
+func recordRestore(sourceName string, drift []fieldDrift) (time.Duration, int, []string) {
+	contentionLock.Lock()
+	defer contentionLock.Unlock()
+
+	now := time.Now()
+	c, present := contentions[sourceName]
+	if !present {
+		c = &contention{}
+		contentions[sourceName] = c
+	}
+	c.prune(now)
+	c.restores = append(c.restores, now)
+	for _, field := range drift {
+		for _, writer := range field.writers {
+			if !containsString(c.writers, writer) {
+				c.writers = append(c.writers, writer)
+			}
+		}
+	}
+	sort.Strings(c.writers)
+	if len(c.restores) < FlappingThreshold {
+		return 0, len(c.restores), c.writers
+	}
+
+	backoff := MaxFlappingBackoff
+	if c.backoffs < 32 && FlappingBackoff<<c.backoffs < MaxFlappingBackoff {
+		backoff = FlappingBackoff << c.backoffs
+	}
+	c.backoffs++
+	c.until = now.Add(backoff)
+	reportContested()
+	return backoff, len(c.restores), c.writers
+}
+
+// settleContention is called when the given CatalogSource did not need to be
+// restored. The CatalogSource is no longer contested once it has been restored
+// fewer than FlappingThreshold times within FlappingWindow, and is forgotten
+// once it has not been restored within FlappingWindow.
+func settleContention(sourceName string) {
+	contentionLock.Lock()
+	defer contentionLock.Unlock()
+
+	c, present := contentions[sourceName]
+	if !present {
+		return
+	}
+	now := time.Now()
+	c.prune(now)
+	if len(c.restores) == 0 {
+		delete(contentions, sourceName)
+		reportContested()
+		return
+	}
+	if c.backoffs > 0 && len(c.restores) < FlappingThreshold && !now.Before(c.until) {
+		c.backoffs = 0
+		reportContested()
+	}
+}
+
+// reportContested reports the contested CatalogSources through the
+// ClusterOperator. The caller is expected to hold contentionLock.
+func reportContested() {
+	var names []string
+	for name, c := range contentions {
+		if c.backoffs > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		status.ClearDegraded(ContestedSourcesReason)
+		return
+	}
+	sort.Strings(names)
+	sources := make([]string, 0, len(names))
+	for _, name := range names {
+		sources = append(sources, fmt.Sprintf("%s by %s", name, strings.Join(contentions[name].writers, ", ")))
+	}
+	status.SetDegraded(ContestedSourcesReason, fmt.Sprintf("Default CatalogSources keep being changed by other field managers and restoring them is backed off: %s", strings.Join(sources, "; ")))
+}
+
+// containsString returns true if the given slice contains the given string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
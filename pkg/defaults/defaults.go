@@ -2,12 +2,22 @@ package defaults
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
 	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	semver "github.com/blang/semver/v4"
 	"github.com/containers/image/docker/reference"
@@ -25,87 +35,328 @@ var (
 	// injected into the operator image.
 	globalCatsrcDefinitions = make(map[string]olmv1alpha1.CatalogSource)
 
+	// globalCompanions maps the name of each default CatalogSource to the
+	// companion objects that are managed along with it.
+	globalCompanions = make(map[string][]unstructured.Unstructured)
+
+	// globalNotApplicable maps the name of each default CatalogSource that
+	// does not apply to the cluster version to the reason why.
+	globalNotApplicable = make(map[string]string)
+
 	// defaultConfig is the default configuration for the cluster in the absence
 	// of a an OperatorHub config object or if there is one with an empty spec.
 	// The default is for all the CatalogSources in the globalDefinitions to be
 	// enabled.
 	defaultConfig = make(map[string]bool)
+
+	// dirDefinitions and configMapDefinitions are the definitions loaded from
+	// Dir and from the defaults ConfigMaps respectively. They are merged into
+	// the global definitions and config by mergeGlobals.
+	dirDefinitions       = newDefinitionSet()
+	configMapDefinitions = newDefinitionSet()
+
+	// dirDefinitionsLoaded and configMapDefinitionsLoaded are set once the
+	// definitions have been loaded from Dir and from the defaults ConfigMaps
+	// respectively. configMapDefinitionsExpected is set when the defaults
+	// ConfigMaps are watched, so that orphans are not deleted before their
+	// definitions are loaded.
+	dirDefinitionsLoaded         bool
+	configMapDefinitionsLoaded   bool
+	configMapDefinitionsExpected bool
+
+	// globalTemplateContext is the context the globals were populated with.
+	// It is reused when the globals are reloaded.
+	globalTemplateContext TemplateContext
+
+	// globalsLock guards the global definitions and config. The maps are
+	// never modified once populated, they are only ever swapped out as a
+	// whole, so it is safe to hand them out to callers.
+	globalsLock sync.RWMutex
 )
 
 const (
 	defaultCatsrcAnnotationKey   string = "operatorframework.io/managed-by"
 	defaultCatsrcAnnotationValue string = "marketplace-operator"
 	defaultCatsrcVersionString   string = "0.0.1-snapshot"
+
+	// FieldManager is the field manager the default CatalogSources are
+	// applied with.
+	FieldManager string = "marketplace-operator"
+
+	// ImageTagTemplateAnnotationKey is the annotation on a default definition
+	// that holds the template its image tag is rewritten with. The template is
+	// evaluated against the Major, Minor and Patch parts of the release version.
+	ImageTagTemplateAnnotationKey string = "operatorframework.io/image-tag-template"
 )
 
 // Defaults is the interface that can be used to ensure the default set
 // of CatalogSource resources are always present on cluster.
 type Defaults interface {
-	EnsureAll(ctx context.Context, client wrapper.Client) map[string]error
+	EnsureAll(ctx context.Context, client wrapper.Client) map[string]Result
 	Ensure(ctx context.Context, client wrapper.Client, sourceName string) error
+	EnsureSource(ctx context.Context, client wrapper.Client, sourceName string) Result
+	SweepOrphans(ctx context.Context, client wrapper.Client) ([]Orphan, error)
+}
+
+// Action is an action taken on a default CatalogSource
+type Action string
+
+const (
+	// ActionNone is reported when the CatalogSource is already in the
+	// desired state
+	ActionNone Action = ""
+	// ActionCreate is reported when the CatalogSource is created
+	ActionCreate Action = "Create"
+	// ActionUpdate is reported when the CatalogSource is updated because its
+	// definition changed
+	ActionUpdate Action = "Update"
+	// ActionRestore is reported when fields changed on the cluster are
+	// restored
+	ActionRestore Action = "Restore"
+	// ActionDelete is reported when a disabled CatalogSource is deleted
+	ActionDelete Action = "Delete"
+	// ActionAdopt is reported when a CatalogSource that was not created by
+	// the operator is taken over
+	ActionAdopt Action = "Adopt"
+	// ActionConflict is reported when a CatalogSource that was not created
+	// by the operator is left as it is, either because it is not adopted or
+	// because it is disabled
+	ActionConflict Action = "Conflict"
+	// ActionUnmanaged is reported when the CatalogSource is left as it is
+	// because it is annotated as unmanaged
+	ActionUnmanaged Action = "Unmanaged"
+	// ActionContested is reported when fields changed on the cluster are not
+	// restored because another field manager keeps changing them
+	ActionContested Action = "Contested"
+	// ActionFieldConflict is reported when fields of the CatalogSource or of
+	// its companion objects are owned by other field managers and are not
+	// taken over because of the conflict policy
+	ActionFieldConflict Action = "FieldConflict"
+	// ActionWaitForDeletion is reported when the CatalogSource is being
+	// deleted and is recreated once it is gone
+	ActionWaitForDeletion Action = "WaitForDeletion"
+)
+
+// Result is the outcome of ensuring a default CatalogSource
+type Result struct {
+	// Action is the action taken on the CatalogSource. In dry-run mode it is
+	// the action that would have been taken.
+	Action Action
+	// DryRun is true if the action was computed without being taken
+	DryRun bool
+	// Attempts is the number of times ensuring the CatalogSource was
+	// attempted
+	Attempts int
+	// ErrorClass is the class of Err
+	ErrorClass ErrorClass
+	// Err is the error that prevented the CatalogSource from being ensured
+	Err error
+	// ContestedBy are the field managers that keep changing the CatalogSource
+	// when Action is ActionContested
+	ContestedBy []string
+	// Conflicts are the fields that are owned by other field managers when
+	// Action is ActionFieldConflict
+	Conflicts []string
+	// RequeueAfter is set when the CatalogSource has to be ensured again
+	// after the given time, such as when it is waiting to be recreated or
+	// failed with a retriable error
+	RequeueAfter time.Duration
 }
 
 type defaults struct {
 	catsrcDefinitions map[string]olmv1alpha1.CatalogSource
+	companions        map[string][]unstructured.Unstructured
+	overrides         map[string]Override
 	config            map[string]bool
+	recorder          *eventRecorder
+	dryRun            bool
+	conflictRetry     wait.Backoff
+	backoff           wait.Backoff
+}
+
+// Option configures optional behavior of the Defaults returned by New
+type Option func(*defaults)
+
+// WithCompanions sets the companion objects that are ensured along with each
+// default CatalogSource, keyed by the name of the CatalogSource.
+func WithCompanions(companions map[string][]unstructured.Unstructured) Option {
+	return func(d *defaults) {
+		d.companions = companions
+	}
+}
+
+// WithOverrides sets the overrides that are merged onto the default
+// CatalogSource definitions before they are ensured, keyed by the name of the
+// CatalogSource.
+func WithOverrides(overrides map[string]Override) Option {
+	return func(d *defaults) {
+		d.overrides = overrides
+	}
 }
 
 // New returns an instance of defaults
-func New(catsrcDefinitions map[string]olmv1alpha1.CatalogSource, config map[string]bool) Defaults {
+func New(catsrcDefinitions map[string]olmv1alpha1.CatalogSource, config map[string]bool, opts ...Option) Defaults {
 	// Doing this to remove the need for checking at calls sites. This can be
 	// made to return an error if error checking at calls sites is preferable.
 	if catsrcDefinitions == nil || config == nil {
 		panic("Defaults cannot be initialized with nil definitions or config")
 	}
-	return &defaults{
+	d := &defaults{
 		catsrcDefinitions: catsrcDefinitions,
 		config:            config,
+		conflictRetry:     EnsureConflictRetry,
+		backoff:           EnsureBackoff,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // Ensure checks if the given CatalogSource source is one of the
 // defaults and if it is, it ensures it is present or absent on the cluster
 // based on the config.
 func (d *defaults) Ensure(ctx context.Context, client wrapper.Client, sourceName string) error {
+	return d.EnsureSource(ctx, client, sourceName).Err
+}
+
+// EnsureSource is Ensure, but returns the outcome for the CatalogSource
+func (d *defaults) EnsureSource(ctx context.Context, client wrapper.Client, sourceName string) Result {
 	catsrc, present := d.catsrcDefinitions[sourceName]
 	if !present {
-		return nil
+		setUnmanaged(sourceName, false)
+		setStuckTerminating(sourceName, "")
+		return Result{}
+	}
+	dryRun := d.dryRun || DryRun
+
+	// An invalid override is reported once the definition has been ensured
+	// without it, so that the default CatalogSource is still reconciled
+	var overrideErr error
+	if override, present := d.overrides[sourceName]; present {
+		catsrc = *catsrc.DeepCopy()
+		overrideErr = applyOverride(&catsrc, override)
 	}
-	return ensureCatsrc(ctx, client, d.config, catsrc)
+
+	var action Action
+	var conflicts []string
+	attempts, err := withRetries(d.conflictRetry, func() error {
+		var err error
+		action, err = ensureCatsrc(ctx, client, d.recorder, d.config, catsrc, d.companions[sourceName], dryRun)
+		// A conflict is reported rather than retried, it is not going away
+		// on its own
+		var conflict *fieldConflictError
+		if errors.As(err, &conflict) {
+			action, conflicts, err = ActionFieldConflict, conflict.conflicts, nil
+		}
+		return err
+	})
+	errorClass := classifyError(err)
+	if err == nil && overrideErr != nil {
+		err, errorClass = overrideErr, ErrorClassPermanent
+	}
+	if err != nil {
+		d.recorder.warning(clusterCatsrc(ctx, client, &catsrc), FailedReason, "Reconcile", "Failed to reconcile the default CatalogSource after %d attempts: %v", attempts, err)
+		metrics.DefaultCatalogEnsureFailures.WithLabelValues(sourceName, string(errorClass)).Inc()
+	}
+	setDryRunAction(sourceName, action, dryRun)
+	if err == nil && action == ActionNone {
+		// A CatalogSource in sync is in no state worth reporting, the
+		// events about its next state are recorded again
+		setReportedState(sourceName, "")
+	}
+	if err == nil {
+		setUnmanaged(sourceName, action == ActionUnmanaged)
+	}
+	result := Result{Action: action, DryRun: dryRun, Attempts: attempts, ErrorClass: errorClass, Err: err, Conflicts: conflicts}
+	switch {
+	case action == ActionWaitForDeletion:
+		result.RequeueAfter = TerminationRequeueInterval
+	case errorClass.retriable():
+		result.RequeueAfter = nextRetry(sourceName, d.backoff)
+	}
+	if !errorClass.retriable() {
+		resetRetry(sourceName)
+	}
+	if action != ActionWaitForDeletion && err == nil {
+		setStuckTerminating(sourceName, "")
+	}
+	switch {
+	case action == ActionContested:
+		result.RequeueAfter, result.ContestedBy = contested(sourceName)
+	case err == nil && action != ActionRestore:
+		settleContention(sourceName)
+	}
+	return result
 }
 
 // EnsureAll processes all the default Catalogsources and ensures they are present
-// or absent on the cluster based on the config.
-func (d *defaults) EnsureAll(ctx context.Context, client wrapper.Client) map[string]error {
-	result := make(map[string]error)
+// or absent on the cluster based on the config. Up to EnsureWorkers of them
+// are processed concurrently, and each of them is retried on its own. It
+// returns the outcome for each of them.
+func (d *defaults) EnsureAll(ctx context.Context, client wrapper.Client) map[string]Result {
+	names := make([]string, 0, len(d.config))
 	for name := range d.config {
-		err := d.Ensure(ctx, client, name)
-		if err != nil {
-			result[name] = err
-		}
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	var lock sync.Mutex
+	result := make(map[string]Result)
+	forEachConcurrently(names, EnsureWorkers, func(name string) {
+		r := d.EnsureSource(ctx, client, name)
+		lock.Lock()
+		defer lock.Unlock()
+		result[name] = r
+	})
 	return result
 }
 
 // GetGlobals returns the global CatalogSource definitions and the
 // default config
 func GetGlobals() (map[string]olmv1alpha1.CatalogSource, map[string]bool) {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
 	return globalCatsrcDefinitions, defaultConfig
 }
 
 // GetGlobalCatalogSourceDefinitions returns the global CatalogSource definitions
 func GetGlobalCatalogSourceDefinitions() map[string]olmv1alpha1.CatalogSource {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
 	return globalCatsrcDefinitions
 }
 
+// GetGlobalCompanions returns the companion objects of the global
+// CatalogSource definitions
+func GetGlobalCompanions() map[string][]unstructured.Unstructured {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
+	return globalCompanions
+}
+
+// GetNotApplicableReason returns the reason the given CatalogSource is
+// excluded from the default definitions by its cluster version constraints.
+// It returns false if no such definition was excluded.
+func GetNotApplicableReason(name string) (string, bool) {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
+	reason, present := globalNotApplicable[name]
+	return reason, present
+}
+
 // GetDefaultConfig returns the global OperatorHub configuration
 func GetDefaultConfig() map[string]bool {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
 	return defaultConfig
 }
 
 // IsDefaultSource returns true if the given name is one of the default
 // CatalogSources
 func IsDefaultSource(name string) bool {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
 	_, present := defaultConfig[name]
 	return present
 
@@ -113,96 +364,260 @@ func IsDefaultSource(name string) bool {
 
 // PopulateGlobals populates the global definitions and default config. If Dir
 // is blank, the global definitions and config will be initialized but empty.
-// imageTagOverride updates the image tags for the default catalogSources with
-// the given non-empty tag.
-func PopulateGlobals(imageTagOverride string) error {
-	var err error
-	globalCatsrcDefinitions, defaultConfig, err = populateDefsConfig(Dir, imageTagOverride)
+// The definitions are rendered with the given TemplateContext. Files that fail
+// to load are skipped and reported through GetLoadErrors. An error is only returned if none of the files in Dir
+// could be loaded.
+func PopulateGlobals(templateContext TemplateContext) error {
+	set, err := populateDefsConfig(Dir, templateContext)
+	if err == nil {
+		err = checkLoadErrors(set)
+	}
+	if err != nil {
+		set = newDefinitionSet()
+	}
+
+	globalsLock.Lock()
+	defer globalsLock.Unlock()
+	dirDefinitions, globalTemplateContext = set, templateContext
+	dirDefinitionsLoaded = err == nil
+	mergeGlobals()
 	return err
 }
 
-// populateDefsConfig returns populated CatalogSource definitions from files present
-// in the @dir directory and an enabled config. It returns error on the first
-// issue it runs into. The function also guarantees to return an empty map on error.
-func populateDefsConfig(dir, imageTagOverride string) (map[string]olmv1alpha1.CatalogSource, map[string]bool, error) {
+// ReloadGlobals re-reads the definitions from Dir and atomically swaps them
+// in place of the current ones. The current definitions are left untouched if
+// none of the files in Dir could be loaded. It returns true if the definitions
+// or the load errors changed.
+func ReloadGlobals() (bool, error) {
+	globalsLock.RLock()
+	templateContext := globalTemplateContext
+	globalsLock.RUnlock()
+
+	set, err := populateDefsConfig(Dir, templateContext)
+	if err == nil {
+		err = checkLoadErrors(set)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	globalsLock.Lock()
+	defer globalsLock.Unlock()
+	dirDefinitionsLoaded = true
+	if set.equal(dirDefinitions) {
+		return false, nil
+	}
+	dirDefinitions = set
+	mergeGlobals()
+	return true, nil
+}
+
+// mergeGlobals merges the definitions from Dir and from the defaults
+// ConfigMaps into the global definitions and config. Definitions from Dir
+// always take precedence, a ConfigMap definition with the same name as one of
+// them is rejected and reported as a load error. The caller is expected to
+// hold globalsLock.
+func mergeGlobals() {
 	catsrcDefinitions := make(map[string]olmv1alpha1.CatalogSource)
+	companions := make(map[string][]unstructured.Unstructured)
 	config := make(map[string]bool)
+	var loadErrors []LoadError
+	loadErrors = append(loadErrors, dirDefinitions.loadErrors...)
+	loadErrors = append(loadErrors, configMapDefinitions.loadErrors...)
+
+	for name, catsrc := range dirDefinitions.catsrcDefinitions {
+		catsrcDefinitions[name] = catsrc
+		if group, present := dirDefinitions.companions[name]; present {
+			companions[name] = group
+		}
+		config[name] = false
+	}
+	for _, name := range configMapDefinitions.names() {
+		origin := configMapDefinitions.origins[name]
+		if previous, present := dirDefinitions.origins[name]; present {
+			loadErrors = append(loadErrors, LoadError{
+				File: origin,
+				Err:  fmt.Errorf("default CatalogSource %s is defined in both %s and %s, the definition in the defaults directory takes precedence", name, previous, origin),
+			})
+			continue
+		}
+		catsrcDefinitions[name] = configMapDefinitions.catsrcDefinitions[name]
+		if group, present := configMapDefinitions.companions[name]; present {
+			companions[name] = group
+		}
+		config[name] = false
+	}
+
+	notApplicable := make(map[string]string)
+	for _, set := range []definitionSet{configMapDefinitions, dirDefinitions} {
+		for name, reason := range set.notApplicable {
+			if _, present := catsrcDefinitions[name]; !present {
+				notApplicable[name] = reason
+			}
+		}
+	}
+
+	globalCatsrcDefinitions, globalCompanions, defaultConfig = catsrcDefinitions, companions, config
+	globalNotApplicable = notApplicable
+	setLoadErrors(loadErrors)
+}
+
+// populateDefsConfig returns the CatalogSource definitions from the manifests
+// found in the @dir directory tree. A file that fails to load is skipped as a
+// whole and reported in the load errors of the returned set. An error is only
+// returned if the directory cannot be read.
+func populateDefsConfig(dir string, templateContext TemplateContext) (definitionSet, error) {
+	set := newDefinitionSet()
 	// Default directory has not been specified
 	if dir == "" {
-		return catsrcDefinitions, config, nil
+		return set, nil
 	}
 
 	_, err := os.Stat(dir)
 	if err != nil {
-		return catsrcDefinitions, config, err
+		return set, err
 	}
 
-	fileInfos, err := ioutil.ReadDir(dir)
+	err = walkManifests(dir, func(fileName string) error {
+		catsrcs, companions, err := getDefinitions(filepath.Join(dir, fileName), templateContext)
+		if err == nil {
+			err = set.add(fileName, catsrcs, companions, templateContext)
+		}
+		if err != nil {
+			set.loadErrors = append(set.loadErrors, LoadError{File: fileName, Err: err})
+		}
+		return nil
+	})
 	if err != nil {
-		return catsrcDefinitions, config, err
+		return newDefinitionSet(), err
 	}
+	return set, nil
+}
 
-	for _, fileInfo := range fileInfos {
-		fileName := fileInfo.Name()
-		catsrc, err := getCatsrcDefinition(fileName)
-		if err != nil {
-			// Reinitialize the definitions as we hard error on even one failure
-			catsrcDefinitions = make(map[string]olmv1alpha1.CatalogSource)
-			config = make(map[string]bool)
-			return catsrcDefinitions, config, err
+// walkManifests calls fn with the path, relative to dir, of every manifest
+// file in the dir directory tree in lexical order. Hidden files and
+// directories, such as the ..data directories of a mounted ConfigMap, and
+// files without a manifest extension are skipped. Symlinked files and
+// directories are followed, so that the nested keys of a mounted ConfigMap or
+// projected volume, which link into its ..data directory, are loaded.
+func walkManifests(dir string, fn func(fileName string) error) error {
+	return walkTree(dir, func(fileName string, isDir bool) error {
+		if isDir || !isManifestFile(fileName) {
+			return nil
 		}
+		return fn(fileName)
+	})
+}
 
-		// Override image tags with ones matching OpenShift <major>.<minor> for
-		// default CatalogSources
-		if err = overrideImageTag(catsrc, imageTagOverride); err != nil {
-			return map[string]olmv1alpha1.CatalogSource{}, map[string]bool{},
-				fmt.Errorf("unable to update image tags for default CatalogSource %s: %w", catsrc.Name, err)
+// walkTree calls fn with the path, relative to dir, of every non-hidden file
+// and directory in the dir directory tree in lexical order, following
+// symlinks. Each directory is only walked once, even if it is linked more
+// than once, so that symlink loops terminate.
+func walkTree(dir string, fn func(fileName string, isDir bool) error) error {
+	visited := make(map[string]bool)
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := os.ReadDir(filepath.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			fileName := filepath.Join(rel, entry.Name())
+			isDir := entry.IsDir()
+			if entry.Type()&fs.ModeSymlink != 0 {
+				// A dangling symlink is passed on as a file, so that a
+				// manifest that cannot be read is reported
+				if info, err := os.Stat(filepath.Join(dir, fileName)); err == nil {
+					isDir = info.IsDir()
+				}
+			}
+			if isDir {
+				realPath, err := filepath.EvalSymlinks(filepath.Join(dir, fileName))
+				if err != nil {
+					return err
+				}
+				if visited[realPath] {
+					continue
+				}
+				visited[realPath] = true
+			}
+			if err := fn(fileName, isDir); err != nil {
+				return err
+			}
+			if isDir {
+				if err := walk(fileName); err != nil {
+					return err
+				}
+			}
 		}
+		return nil
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	visited[realDir] = true
+	return walk("")
+}
 
-		catsrcDefinitions[catsrc.Name] = *catsrc
-		config[catsrc.Name] = false
+// isManifestFile returns true if the file name has one of the extensions
+// supported for default definitions
+func isManifestFile(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml", ".json":
+		return true
 	}
-	return catsrcDefinitions, config, nil
+	return false
 }
 
-// GetCatalogSourceImageTagOverride returns a tag of the form `v<major>.<minor>`
-// where <major> and <minor> are the major and minor version parts of the semver
-// argument provided through versionString, provided the version string has a
-// major version of 4. This is used for determining what image tag to use on
-// a default CatalogSource based on the OCP version of the cluster it is running on,
-// given the 5.0 catalogsources will be shipped to both 4.23 and 5.0 clusters.
-// This may be removed in 5.1+
-func GetCatalogSourceImageTagOverride(versionString string) (string, error) {
-	// Return empty if not in OpenShift or version is default/unknown
+// ParseReleaseVersion parses the release version of the cluster the operator
+// is running on. It returns nil if the version is empty or the default
+// snapshot version, which is the case outside of an OpenShift release payload.
+func ParseReleaseVersion(versionString string) (*semver.Version, error) {
+	// Return nil if not in OpenShift or version is default/unknown
 	if len(versionString) == 0 || versionString == defaultCatsrcVersionString {
-		return "", nil
+		return nil, nil
 	}
 
 	v, err := semver.Parse(versionString)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse version string %q: %w", versionString, err)
-	}
-
-	// Only override for 4.x OpenShift versions
-	if v.Major != 4 {
-		return "", nil
+		return nil, fmt.Errorf("failed to parse version string %q: %w", versionString, err)
 	}
+	return &v, nil
+}
 
-	return fmt.Sprintf("v%d.%d", v.Major, v.Minor), nil
+// imageTagContext is what image tag templates are evaluated against, the
+// TemplateContext along with the current tag of the image
+type imageTagContext struct {
+	TemplateContext
+	Tag string
 }
 
-// overrideImageTag overrides the tag for a given CatalogSource's image with
-// a tag exactly matching `v5.0`, provided the CatalogSource has a non-empty image field
-// The image tag override only applies to non-digest based images. If called on a
-// CatalogSource with a digest based image, the image remains unchanged.
-func overrideImageTag(catsrc *olmv1alpha1.CatalogSource, imageTagOverride string) error {
-	if len(imageTagOverride) == 0 {
+// overrideImageTag replaces the tag of a given CatalogSource's image with the
+// result of evaluating the template in its image tag template annotation
+// against the TemplateContext and the current Tag, for example
+// `v{{.Major}}.{{.Minor}}`. Nothing
+// is changed if the CatalogSource has no template or no image, or if the
+// release version is unknown. The image tag override only applies to
+// non-digest based images. If called on a CatalogSource with a digest based
+// image, the image remains unchanged.
+func overrideImageTag(catsrc *olmv1alpha1.CatalogSource, templateContext TemplateContext) error {
+	if templateContext.releaseVersion == nil {
 		return nil
 	}
 	if catsrc == nil {
 		return nil
 	}
 
+	tagTemplate, present := catsrc.Annotations[ImageTagTemplateAnnotationKey]
+	if !present {
+		return nil
+	}
+
 	// Do not override image tags for non-image based CatalogSources
 	if len(catsrc.Spec.Image) == 0 {
 		return nil
@@ -219,15 +634,23 @@ func overrideImageTag(catsrc *olmv1alpha1.CatalogSource, imageTagOverride string
 		return nil
 	}
 
-	// Tag substitution should only happen on v5.0 images
-	if taggedRef, ok := catsrcRef.(reference.Tagged); !ok || taggedRef.Tag() != "v5.0" {
-		return nil
+	tmpl, err := template.New(ImageTagTemplateAnnotationKey).Option("missingkey=error").Parse(tagTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid image tag template %q for CatalogSource %s: %w", tagTemplate, catsrc.Name, err)
+	}
+	tagContext := imageTagContext{TemplateContext: templateContext}
+	if tagged, ok := catsrcRef.(reference.Tagged); ok {
+		tagContext.Tag = tagged.Tag()
+	}
+	tag := &strings.Builder{}
+	if err := tmpl.Execute(tag, tagContext); err != nil {
+		return fmt.Errorf("invalid image tag template %q for CatalogSource %s: %w", tagTemplate, catsrc.Name, err)
 	}
 
 	// Override reference tag
-	taggedRef, err := reference.WithTag(catsrcRef, imageTagOverride)
+	taggedRef, err := reference.WithTag(reference.TrimNamed(catsrcRef), tag.String())
 	if err != nil {
-		return fmt.Errorf("unable to update tag on image %s to %s: %w", catsrc.Spec.Image, imageTagOverride, err)
+		return fmt.Errorf("unable to update tag on image %s to %s: %w", catsrc.Spec.Image, tag.String(), err)
 	}
 
 	catsrc.Spec.Image = taggedRef.String()
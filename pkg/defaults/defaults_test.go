@@ -1,238 +1,2037 @@
 package defaults
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/containers/image/docker/reference"
+	"github.com/fsnotify/fsnotify"
+	configv1 "github.com/openshift/api/config/v1"
 	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/workqueue"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
-func TestGetCatalogSourceImageTagOverride(t *testing.T) {
+// resetState clears the state the package keeps across reconciles, the
+// loaded definitions and the policies, before the test and once it is done,
+// so that tests do not depend on what ran before them
+func resetState(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		Dir, DryRun, MirrorImages = "", false, false
+		DefaultsAdoptionPolicy, DefaultsConflictPolicy, OrphanedDefaultsPolicy = AdoptionPolicyAlways, ConflictPolicyForce, OrphanPolicyWarn
+
+		globalsLock.Lock()
+		dirDefinitions, configMapDefinitions = newDefinitionSet(), newDefinitionSet()
+		dirDefinitionsLoaded, configMapDefinitionsLoaded, configMapDefinitionsExpected = false, false, false
+		globalTemplateContext = TemplateContext{}
+		mergeGlobals()
+		globalsLock.Unlock()
+
+		contentionLock.Lock()
+		contentions = make(map[string]*contention)
+		contentionLock.Unlock()
+		backoffLock.Lock()
+		backoffs = make(map[string]*wait.Backoff)
+		backoffLock.Unlock()
+		unmanagedLock.Lock()
+		unmanagedSources = make(map[string]bool)
+		unmanagedLock.Unlock()
+		stuckLock.Lock()
+		stuckSources = make(map[string]string)
+		stuckLock.Unlock()
+		diagnosesLock.Lock()
+		diagnoses = make(map[string]Diagnosis)
+		diagnosesLock.Unlock()
+		reportedStatesLock.Lock()
+		reportedStates = make(map[string]string)
+		reportedStatesLock.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestParseReleaseVersion(t *testing.T) {
+	resetState(t)
 	tests := []struct {
 		name          string
 		versionString string
-		wantTag       string
+		wantVersion   string
 		wantErr       bool
 	}{
 		{
 			name:          "empty version string",
 			versionString: "",
-			wantTag:       "",
+			wantVersion:   "",
 			wantErr:       false,
 		},
 		{
 			name:          "default snapshot version",
 			versionString: "0.0.1-snapshot",
-			wantTag:       "",
+			wantVersion:   "",
 			wantErr:       false,
 		},
 		{
 			name:          "valid OpenShift 4.23.0",
 			versionString: "4.23.0",
-			wantTag:       "v4.23",
+			wantVersion:   "4.23.0",
 			wantErr:       false,
 		},
 		{
-			name:          "OpenShift 5.0.0 ignored",
+			name:          "OpenShift 5.0.0",
 			versionString: "5.0.0",
-			wantTag:       "",
+			wantVersion:   "5.0.0",
 			wantErr:       false,
 		},
 		{
 			name:          "version with pre-release",
 			versionString: "4.21.0-rc.1",
-			wantTag:       "v4.21",
+			wantVersion:   "4.21.0-rc.1",
 			wantErr:       false,
 		},
 		{
 			name:          "version with build metadata",
 			versionString: "4.23.0+build123",
-			wantTag:       "v4.23",
-			wantErr:       false,
-		},
-		{
-			name:          "zero major version (development)",
-			versionString: "0.5.0",
-			wantTag:       "",
-			wantErr:       false,
-		},
-		{
-			name:          "zero major and minor",
-			versionString: "0.0.1",
-			wantTag:       "",
+			wantVersion:   "4.23.0+build123",
 			wantErr:       false,
 		},
 		{
 			name:          "invalid semver - not a version",
 			versionString: "not-a-version",
-			wantTag:       "",
 			wantErr:       true,
 		},
 		{
 			name:          "invalid semver - v prefix",
 			versionString: "v4.23.0",
-			wantTag:       "",
 			wantErr:       true,
 		},
 		{
 			name:          "invalid semver - missing patch",
 			versionString: "4.23",
-			wantTag:       "",
 			wantErr:       true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotTag, err := GetCatalogSourceImageTagOverride(tt.versionString)
+			gotVersion, err := ParseReleaseVersion(tt.versionString)
 			if tt.wantErr {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), "failed to parse version string")
+				assert.Nil(t, gotVersion)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantVersion == "" {
+				assert.Nil(t, gotVersion)
 			} else {
-				assert.NoError(t, err)
+				require.NotNil(t, gotVersion)
+				assert.Equal(t, tt.wantVersion, gotVersion.String())
 			}
-			assert.Equal(t, tt.wantTag, gotTag)
 		})
 	}
 }
 
+// TestShippedImageTagOverride checks that the shipped definitions only point
+// their v5.0 images at v<major>.<minor> on 4.x clusters, as the 5.0 catalogs
+// are shipped to both 4.23 and 5.0 clusters
+func TestShippedImageTagOverride(t *testing.T) {
+	resetState(t)
+	tests := []struct {
+		name          string
+		versionString string
+		wantTag       string
+	}{
+		{"empty version string", "", "v5.0"},
+		{"default snapshot version", "0.0.1-snapshot", "v5.0"},
+		{"valid OpenShift 4.23.0", "4.23.0", "v4.23"},
+		{"OpenShift 5.0.0 ignored", "5.0.0", "v5.0"},
+		{"OpenShift 5.1.0 ignored", "5.1.0", "v5.0"},
+		{"version with pre-release", "4.21.0-rc.1", "v4.21"},
+		{"version with build metadata", "4.23.0+build123", "v4.23"},
+		{"zero major version (development)", "0.5.0", "v5.0"},
+		{"zero major and minor", "0.0.1", "v5.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releaseVersion, err := ParseReleaseVersion(tt.versionString)
+			require.NoError(t, err)
+			set, err := populateDefsConfig("../../defaults", NewTemplateContext("openshift-marketplace", releaseVersion, nil))
+			require.NoError(t, err)
+			require.Empty(t, set.loadErrors)
+			require.Len(t, set.catsrcDefinitions, 3)
+			for _, catsrc := range set.catsrcDefinitions {
+				assert.True(t, strings.HasSuffix(catsrc.Spec.Image, ":"+tt.wantTag), catsrc.Spec.Image)
+			}
+		})
+	}
+
+	// Images that are not tagged v5.0 are left as they are
+	releaseVersion, err := ParseReleaseVersion("4.23.0")
+	require.NoError(t, err)
+	catsrc := &olmv1alpha1.CatalogSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: map[string]string{ImageTagTemplateAnnotationKey: `{{if and (eq .Major 4) (eq .Tag "v5.0")}}v{{.Major}}.{{.Minor}}{{else}}{{.Tag}}{{end}}`}},
+		Spec:       olmv1alpha1.CatalogSourceSpec{Image: "registry.io/catalog:v4.99"},
+	}
+	require.NoError(t, overrideImageTag(catsrc, NewTemplateContext("openshift-marketplace", releaseVersion, nil)))
+	assert.Equal(t, "registry.io/catalog:v4.99", catsrc.Spec.Image)
+}
+
 func TestOverrideImageTag(t *testing.T) {
+	resetState(t)
+	newCatsrc := func(image, tagTemplate string) *olmv1alpha1.CatalogSource {
+		catsrc := &olmv1alpha1.CatalogSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Spec: olmv1alpha1.CatalogSourceSpec{
+				Image: image,
+			},
+		}
+		if tagTemplate != "" {
+			catsrc.Annotations = map[string]string{ImageTagTemplateAnnotationKey: tagTemplate}
+		}
+		return catsrc
+	}
+
 	tests := []struct {
-		name             string
-		catsrc           *olmv1alpha1.CatalogSource
-		imageTagOverride string
-		wantImage        string
-		wantErr          bool
+		name           string
+		catsrc         *olmv1alpha1.CatalogSource
+		releaseVersion string
+		wantImage      string
+		wantErr        string
 	}{
 		{
-			name:             "nil CatalogSource",
-			catsrc:           nil,
-			imageTagOverride: "v4.21",
-			wantImage:        "",
-			wantErr:          false,
+			name:           "nil CatalogSource",
+			catsrc:         nil,
+			releaseVersion: "4.21.0",
 		},
 		{
-			name: "empty imageTagOverride",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io/catalog:v5.0",
-				},
-			},
-			imageTagOverride: "",
-			wantImage:        "registry.io/catalog:v5.0",
-			wantErr:          false,
+			name:           "unknown release version",
+			catsrc:         newCatsrc("registry.io/catalog:v5.0", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "",
+			wantImage:      "registry.io/catalog:v5.0",
 		},
 		{
-			name: "empty image field",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec:       olmv1alpha1.CatalogSourceSpec{},
-			},
-			imageTagOverride: "v4.23",
-			wantImage:        "",
-			wantErr:          false,
+			name:           "no template annotation",
+			catsrc:         newCatsrc("registry.io/catalog:v5.0", ""),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/catalog:v5.0",
 		},
 		{
-			name: "tagged image override",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io/catalog:v5.0",
-				},
-			},
-			imageTagOverride: "v4.23",
-			wantImage:        "registry.io/catalog:v4.23",
-			wantErr:          false,
+			name:           "empty image field",
+			catsrc:         newCatsrc("", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "",
 		},
 		{
-			name: "non-v5.0 tagged image unchanged",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io/catalog:latest",
-				},
+			name:           "tagged image",
+			catsrc:         newCatsrc("registry.io/catalog:v5.0", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/catalog:v4.23",
+		},
+		{
+			name:           "untagged image",
+			catsrc:         newCatsrc("registry.io/catalog", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "5.1.0",
+			wantImage:      "registry.io/catalog:v5.1",
+		},
+		{
+			name:           "template using all version parts",
+			catsrc:         newCatsrc("registry.io/catalog:latest", "{{.Major}}.{{.Minor}}.{{.Patch}}-latest"),
+			releaseVersion: "4.23.7-rc.1",
+			wantImage:      "registry.io/catalog:4.23.7-latest",
+		},
+		{
+			name:           "digest-based image unchanged",
+			catsrc:         newCatsrc("registry.io/catalog@sha256:abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/catalog@sha256:abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890",
+		},
+		{
+			name:           "image with port",
+			catsrc:         newCatsrc("registry.io:5000/catalog:v5.0", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io:5000/catalog:v4.23",
+		},
+		{
+			name:           "image with nested path",
+			catsrc:         newCatsrc("registry.io/org/team/catalog:v5.0", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/org/team/catalog:v4.23",
+		},
+		{
+			name:           "invalid image reference",
+			catsrc:         newCatsrc("not:::valid", "v{{.Major}}.{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "not:::valid",
+			wantErr:        "invalid image",
+		},
+		{
+			name:           "unparsable template",
+			catsrc:         newCatsrc("registry.io/catalog:v5.0", "v{{.Major"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/catalog:v5.0",
+			wantErr:        "invalid image tag template",
+		},
+		{
+			name:           "template with unknown field",
+			catsrc:         newCatsrc("registry.io/catalog:v5.0", "v{{.Release}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/catalog:v5.0",
+			wantErr:        "invalid image tag template",
+		},
+		{
+			name:           "template rendering an invalid tag",
+			catsrc:         newCatsrc("registry.io/catalog:v5.0", "v{{.Major}}/{{.Minor}}"),
+			releaseVersion: "4.23.0",
+			wantImage:      "registry.io/catalog:v5.0",
+			wantErr:        "unable to update tag",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releaseVersion, err := ParseReleaseVersion(tt.releaseVersion)
+			require.NoError(t, err)
+
+			err = overrideImageTag(tt.catsrc, NewTemplateContext("", releaseVersion, nil))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			if tt.catsrc != nil {
+				assert.Equal(t, tt.wantImage, tt.catsrc.Spec.Image)
+			}
+		})
+	}
+}
+
+const testCatsrcDefinition = `apiVersion: operators.coreos.com/v1alpha1
+kind: CatalogSource
+metadata:
+  name: %s
+  namespace: openshift-marketplace
+spec:
+  sourceType: grpc
+  image: %s
+`
+
+func writeDefinition(t *testing.T, dir, fileName, name, image string) {
+	t.Helper()
+	content := fmt.Sprintf(testCatsrcDefinition, name, image)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fileName), []byte(content), 0644))
+}
+
+func TestReloadGlobals(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	Dir = dir
+	defer func() { Dir = "" }()
+
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	require.NoError(t, PopulateGlobals(TemplateContext{}))
+	assert.True(t, IsDefaultSource("redhat-operators"))
+
+	// Reloading an unchanged directory is a no-op
+	changed, err := ReloadGlobals()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// New and updated definitions are swapped in
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.24")
+	writeDefinition(t, dir, "02_certified.yaml", "certified-operators", "registry.io/certified:v4.24")
+	changed, err = ReloadGlobals()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, IsDefaultSource("certified-operators"))
+	assert.Equal(t, "registry.io/redhat:v4.24", GetGlobalCatalogSourceDefinitions()["redhat-operators"].Spec.Image)
+
+	// Invalid files are reported while the valid definitions are kept
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "03_invalid.yaml"), []byte("kind: ConfigMap\n"), 0644))
+	changed, err = ReloadGlobals()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, GetGlobalCatalogSourceDefinitions(), 2)
+	require.Len(t, GetLoadErrors(), 1)
+	assert.Equal(t, "03_invalid.yaml", GetLoadErrors()[0].File)
+
+	// A set without any valid definitions leaves the current definitions in place
+	for _, fileName := range []string{"01_redhat.yaml", "02_certified.yaml"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fileName), []byte("kind: ConfigMap\n"), 0644))
+	}
+	changed, err = ReloadGlobals()
+	require.Error(t, err)
+	assert.False(t, changed)
+	assert.Len(t, GetGlobalCatalogSourceDefinitions(), 2)
+	assert.Len(t, GetLoadErrors(), 1)
+}
+
+// recordingWatcher records the directories added to the watch
+type recordingWatcher struct {
+	dirs []string
+}
+
+func (w *recordingWatcher) Add(path string) error {
+	w.dirs = append(w.dirs, path)
+	return nil
+}
+
+func TestWatchCreatedDirectories(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	Dir = dir
+	defer func() { Dir = "" }()
+
+	watcher := &recordingWatcher{}
+	r := &reloader{watcher: watcher, queue: workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]())}
+	defer r.queue.ShutDown()
+	logger := logrus.New()
+
+	// A created directory is watched along with the directories below it
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "new", "nested"), 0755))
+	r.handleFilesystemUpdate(logger, fsnotify.Event{Name: filepath.Join(dir, "new"), Op: fsnotify.Create})
+	assert.Equal(t, []string{filepath.Join(dir, "new"), filepath.Join(dir, "new", "nested")}, watcher.dirs)
+
+	// Files and hidden directories are not watched
+	watcher.dirs = nil
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "..data"), 0755))
+	r.handleFilesystemUpdate(logger, fsnotify.Event{Name: filepath.Join(dir, "01_redhat.yaml"), Op: fsnotify.Create})
+	r.handleFilesystemUpdate(logger, fsnotify.Event{Name: filepath.Join(dir, "..data"), Op: fsnotify.Create})
+	assert.Empty(t, watcher.dirs)
+
+	// The events are merged into a single reload
+	require.Eventually(t, func() bool { return r.queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReload(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	Dir = dir
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	require.NoError(t, PopulateGlobals(TemplateContext{}))
+
+	var reloads int
+	failing := true
+	r := &reloader{
+		logger:  logrus.New(),
+		watcher: &recordingWatcher{},
+		queue:   workqueue.NewTypedRateLimitingQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Millisecond, time.Millisecond)),
+		onReload: func(context.Context) error {
+			reloads++
+			if failing {
+				return errors.New("cache not synced")
+			}
+			return nil
+		},
+	}
+	defer r.queue.ShutDown()
+	ctx := context.TODO()
+
+	// Unchanged definitions are not applied again
+	r.queue.Add(reloadKey)
+	require.True(t, r.processNext(ctx))
+	assert.Zero(t, reloads)
+
+	// Changed definitions are applied until it succeeds, even though they
+	// are unchanged by the time the reload is retried
+	writeDefinition(t, dir, "02_community.yaml", "community-operators", "registry.io/community:v4.23")
+	r.queue.Add(reloadKey)
+	require.True(t, r.processNext(ctx))
+	assert.Equal(t, 1, reloads)
+	assert.True(t, IsDefaultSource("community-operators"))
+	assert.Equal(t, 1, r.queue.NumRequeues(reloadKey))
+
+	failing = false
+	require.True(t, r.processNext(ctx), "the failed reload is requeued")
+	assert.Equal(t, 2, reloads)
+	assert.Zero(t, r.queue.NumRequeues(reloadKey))
+
+	r.queue.Add(reloadKey)
+	require.True(t, r.processNext(ctx))
+	assert.Equal(t, 2, reloads)
+
+	// The worker stops once the queue is shut down
+	r.queue.ShutDown()
+	assert.False(t, r.processNext(ctx))
+}
+
+func TestPopulateGlobalsPartialFailure(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	Dir = dir
+	defer func() { Dir = "" }()
+
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "02_broken.yaml"), []byte("kind: CatalogSource\nmetadata: [\n"), 0644))
+	require.NoError(t, PopulateGlobals(TemplateContext{}))
+	assert.True(t, IsDefaultSource("redhat-operators"))
+	require.Len(t, GetLoadErrors(), 1)
+	assert.Equal(t, "02_broken.yaml", GetLoadErrors()[0].File)
+
+	// Startup fails only when nothing valid loads
+	require.NoError(t, os.Remove(filepath.Join(dir, "01_redhat.yaml")))
+	require.Error(t, PopulateGlobals(TemplateContext{}))
+	assert.Empty(t, GetGlobalCatalogSourceDefinitions())
+	assert.Empty(t, GetLoadErrors())
+}
+
+func TestPopulateDefsConfig(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "..data"), 0755))
+
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	// Multiple documents in a single file, including an empty one
+	multiDoc := "---\n" + fmt.Sprintf(testCatsrcDefinition, "certified-operators", "registry.io/certified:v4.23") +
+		"---\n" + fmt.Sprintf(testCatsrcDefinition, "community-operators", "registry.io/community:v4.23")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nested", "02_multi.yml"), []byte(multiDoc), 0644))
+	// A JSON CatalogSourceList whose items omit their kind
+	list := `{"apiVersion": "operators.coreos.com/v1alpha1", "kind": "CatalogSourceList", "items": [
+		{"metadata": {"name": "house-operators", "namespace": "openshift-marketplace"}, "spec": {"sourceType": "grpc"}}
+	]}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "03_list.json"), []byte(list), 0644))
+	// Files that are not manifests and hidden directories are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# defaults"), 0644))
+	writeDefinition(t, filepath.Join(dir, "..data"), "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+
+	set, err := populateDefsConfig(dir, TemplateContext{})
+	require.NoError(t, err)
+	assert.Empty(t, set.loadErrors)
+	assert.Len(t, set.catsrcDefinitions, 4)
+	for _, name := range []string{"redhat-operators", "certified-operators", "community-operators", "house-operators"} {
+		assert.Contains(t, set.catsrcDefinitions, name)
+	}
+	assert.Equal(t, "nested/02_multi.yml", set.origins["community-operators"])
+	assert.Equal(t, "registry.io/community:v4.23", set.catsrcDefinitions["community-operators"].Spec.Image)
+
+	// Duplicate names across files are rejected
+	writeDefinition(t, dir, "04_duplicate.yaml", "house-operators", "registry.io/house:v4.23")
+	set, err = populateDefsConfig(dir, TemplateContext{})
+	require.NoError(t, err)
+	assert.Len(t, set.catsrcDefinitions, 4)
+	require.Len(t, set.loadErrors, 1)
+	assert.Equal(t, "04_duplicate.yaml", set.loadErrors[0].File)
+	assert.Contains(t, set.loadErrors[0].Error(), "house-operators is defined in both 03_list.json and 04_duplicate.yaml")
+	assert.Equal(t, "", set.catsrcDefinitions["house-operators"].Spec.Image)
+}
+
+func TestPopulateDefsConfigSymlinks(t *testing.T) {
+	resetState(t)
+	// The layout of a projected volume with nested keys, whose files and
+	// directories link into the ..data directory
+	dir := t.TempDir()
+	data := filepath.Join(dir, "..2026_10_17_00_00_00.000000000")
+	require.NoError(t, os.MkdirAll(filepath.Join(data, "nested"), 0755))
+	require.NoError(t, os.Symlink(filepath.Base(data), filepath.Join(dir, "..data")))
+	writeDefinition(t, data, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	writeDefinition(t, filepath.Join(data, "nested"), "02_certified.yaml", "certified-operators", "registry.io/certified:v4.23")
+	require.NoError(t, os.Symlink(filepath.Join("..data", "01_redhat.yaml"), filepath.Join(dir, "01_redhat.yaml")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "nested"), filepath.Join(dir, "nested")))
+	// A symlink loop is only walked once
+	require.NoError(t, os.Symlink(dir, filepath.Join(data, "nested", "loop")))
+
+	set, err := populateDefsConfig(dir, TemplateContext{})
+	require.NoError(t, err)
+	assert.Empty(t, set.loadErrors)
+	assert.Len(t, set.catsrcDefinitions, 2)
+	assert.Equal(t, "01_redhat.yaml", set.origins["redhat-operators"])
+	assert.Equal(t, "nested/02_certified.yaml", set.origins["certified-operators"])
+
+	dirs, err := manifestDirs(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{dir, filepath.Join(dir, "nested")}, dirs)
+}
+
+func TestSetConfigMapDefinitions(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	Dir = dir
+	defer func() {
+		Dir = ""
+		SetConfigMapDefinitions(nil)
+	}()
+
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	require.NoError(t, PopulateGlobals(TemplateContext{}))
+
+	houseDefinition := `apiVersion: operators.coreos.com/v1alpha1
+kind: CatalogSource
+metadata:
+  name: house-operators
+spec:
+  sourceType: grpc
+  image: registry.io/house:latest
+`
+	configMaps := []corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "b-house", Namespace: "openshift-marketplace"},
+			Data: map[string]string{
+				"house.yaml":  houseDefinition,
+				"redhat.yaml": fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/fork:latest"),
+				"README":      "ignored",
 			},
-			imageTagOverride: "v4.23",
-			wantImage:        "registry.io/catalog:latest",
-			wantErr:          false,
 		},
 		{
-			name: "untagged image unchanged",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io/catalog",
-				},
+			ObjectMeta: metav1.ObjectMeta{Name: "a-house", Namespace: "openshift-marketplace"},
+			Data: map[string]string{
+				"house.yaml": strings.Replace(houseDefinition, "latest", "a", 1),
 			},
-			imageTagOverride: "v4.23",
-			wantImage:        "registry.io/catalog",
-			wantErr:          false,
 		},
+	}
+	assert.True(t, SetConfigMapDefinitions(configMaps))
+	assert.False(t, SetConfigMapDefinitions(configMaps))
+
+	definitions := GetGlobalCatalogSourceDefinitions()
+	require.Len(t, definitions, 2)
+	// The defaults directory takes precedence over ConfigMaps
+	assert.Equal(t, "registry.io/redhat:v4.23", definitions["redhat-operators"].Spec.Image)
+	// ConfigMaps are processed in name order and namespaces are defaulted
+	assert.Equal(t, "registry.io/house:a", definitions["house-operators"].Spec.Image)
+	assert.Equal(t, "openshift-marketplace", definitions["house-operators"].Namespace)
+
+	files := []string{}
+	for _, loadError := range GetLoadErrors() {
+		files = append(files, loadError.File)
+	}
+	assert.ElementsMatch(t, []string{"configmaps/b-house/house.yaml", "configmaps/b-house/redhat.yaml"}, files)
+
+	// Removing the ConfigMaps removes their definitions
+	assert.True(t, SetConfigMapDefinitions(nil))
+	assert.False(t, IsDefaultSource("house-operators"))
+	assert.Empty(t, GetLoadErrors())
+}
+
+func TestValidateConfigMapDefinitions(t *testing.T) {
+	resetState(t)
+	catsrc := func(nodeSelector map[string]string, tolerations ...corev1.Toleration) olmv1alpha1.CatalogSource {
+		return olmv1alpha1.CatalogSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "house-operators"},
+			Spec: olmv1alpha1.CatalogSourceSpec{
+				GrpcPodConfig: &olmv1alpha1.GrpcPodConfig{NodeSelector: nodeSelector, Tolerations: tolerations},
+			},
+		}
+	}
+	secret := unstructured.Unstructured{}
+	secret.SetKind("Secret")
+	secret.SetName("house-pull-secret")
+
+	tests := []struct {
+		name       string
+		catsrc     olmv1alpha1.CatalogSource
+		companions []unstructured.Unstructured
+		err        string
+	}{
+		{name: "workers", catsrc: catsrc(map[string]string{"kubernetes.io/os": "linux"}, corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists})},
+		{name: "companions", catsrc: catsrc(nil), companions: []unstructured.Unstructured{secret}, err: "companion Secret house-pull-secret can only be defined in the defaults directory"},
+		{name: "master nodeSelector", catsrc: catsrc(map[string]string{"node-role.kubernetes.io/master": ""}), err: "cannot select the master nodes"},
+		{name: "control plane nodeSelector", catsrc: catsrc(map[string]string{"node-role.kubernetes.io/control-plane": ""}), err: "cannot select the master nodes"},
+		{name: "master toleration", catsrc: catsrc(nil, corev1.Toleration{Key: "node-role.kubernetes.io/master", Operator: corev1.TolerationOpExists}), err: "cannot tolerate the taints of the master nodes"},
+		{name: "every taint", catsrc: catsrc(nil, corev1.Toleration{Operator: corev1.TolerationOpExists}), err: "cannot tolerate the taints of the master nodes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfigMapDefinitions([]olmv1alpha1.CatalogSource{tt.catsrc}, tt.companions)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestResolveMirror(t *testing.T) {
+	resetState(t)
+	mirrors := []imageMirrors{
+		{source: "registry.redhat.io", mirrors: []configv1.ImageMirror{"mirror.example.com/redhat"}},
+		{source: "registry.redhat.io/redhat/redhat-operator-index", mirrors: []configv1.ImageMirror{"mirror.example.com/index", "backup.example.com/index"}},
+		{source: "*.example.org", mirrors: []configv1.ImageMirror{"mirror.example.com/wildcard"}},
+		{source: "quay.io/unmirrored"},
+	}
+
+	tests := []struct {
+		name      string
+		image     string
+		wantImage string
+	}{
 		{
-			name: "digest-based image unchanged",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io/catalog@sha256:abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890",
-				},
+			name:      "most specific source wins",
+			image:     "registry.redhat.io/redhat/redhat-operator-index:v4.23",
+			wantImage: "mirror.example.com/index:v4.23",
+		},
+		{
+			name:      "registry source",
+			image:     "registry.redhat.io/redhat/certified-operator-index:v4.23",
+			wantImage: "mirror.example.com/redhat/redhat/certified-operator-index:v4.23",
+		},
+		{
+			name:      "digest is kept",
+			image:     "registry.redhat.io/redhat/redhat-operator-index@sha256:abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890",
+			wantImage: "mirror.example.com/index@sha256:abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890",
+		},
+		{
+			name:      "untagged image",
+			image:     "registry.redhat.io/redhat/redhat-operator-index",
+			wantImage: "mirror.example.com/index",
+		},
+		{
+			name:      "wildcard source",
+			image:     "registry.example.org/org/catalog:latest",
+			wantImage: "mirror.example.com/wildcard/org/catalog:latest",
+		},
+		{
+			name:      "source only matches whole path components",
+			image:     "registry.redhat.io/redhat/redhat-operator-index-extra:v4.23",
+			wantImage: "mirror.example.com/redhat/redhat/redhat-operator-index-extra:v4.23",
+		},
+		{
+			name:      "source without mirrors",
+			image:     "quay.io/unmirrored/catalog:latest",
+			wantImage: "",
+		},
+		{
+			name:      "no matching source",
+			image:     "quay.io/org/catalog:latest",
+			wantImage: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := reference.ParseNormalizedNamed(tt.image)
+			require.NoError(t, err)
+
+			gotImage, err := resolveMirror(ref, mirrors)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantImage, gotImage)
+		})
+	}
+}
+
+const testCompanionDefinitions = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: house-config
+  labels:
+    operatorframework.io/default-catalogsource: house-operators
+data:
+  key: value
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: house-pull-secret
+  labels:
+    operatorframework.io/default-catalogsource: house-operators
+stringData:
+  token: secret
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: house-operators
+  labels:
+    operatorframework.io/default-catalogsource: house-operators
+spec:
+  podSelector:
+    matchLabels:
+      olm.catalogSource: house-operators
+  ingress:
+  - ports:
+    - port: 50051
+`
+
+func TestPopulateDefsConfigCompanions(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	content := fmt.Sprintf(testCatsrcDefinition, "house-operators", "registry.io/house:v4.23") + "---\n" + testCompanionDefinitions
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "01_house.yaml"), []byte(content), 0644))
+
+	unlabeled := fmt.Sprintf(testCatsrcDefinition, "unlabeled-operators", "registry.io/unlabeled:v4.23") + `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unlabeled
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "02_unlabeled.yaml"), []byte(unlabeled), 0644))
+
+	orphaned := strings.ReplaceAll(testCompanionDefinitions, "house-", "orphaned-")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "03_orphaned.yaml"), []byte(orphaned), 0644))
+
+	deployment := fmt.Sprintf(testCatsrcDefinition, "deployment-operators", "registry.io/deployment:v4.23") + `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: deployment
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "04_deployment.yaml"), []byte(deployment), 0644))
+
+	set, err := populateDefsConfig(dir, TemplateContext{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"house-operators"}, set.names())
+
+	errs := map[string]string{}
+	for _, loadError := range set.loadErrors {
+		errs[loadError.File] = loadError.Err.Error()
+	}
+	assert.Len(t, errs, 3)
+	assert.Contains(t, errs["02_unlabeled.yaml"], "ConfigMap unlabeled has no operatorframework.io/default-catalogsource label")
+	assert.Contains(t, errs["03_orphaned.yaml"], "belongs to CatalogSource orphaned-operators which is not defined in the same file")
+	assert.Contains(t, errs["04_deployment.yaml"], `unsupported kind "Deployment"`)
+
+	companions := set.companions["house-operators"]
+	require.Len(t, companions, 3)
+	for _, companion := range companions {
+		// Companions are placed in the namespace of their CatalogSource
+		assert.Equal(t, "openshift-marketplace", companion.GetNamespace())
+	}
+	// Secret stringData is folded into data
+	data, _, err := unstructured.NestedStringMap(companions[1].Object, "data")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"token": "c2VjcmV0"}, data)
+	assert.NotContains(t, companions[1].Object, "stringData")
+}
+
+func TestEnsureCompanions(t *testing.T) {
+	resetState(t)
+	catsrcs, companions, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "house-operators", "registry.io/house:v4.23") + "---\n" + testCompanionDefinitions))
+	require.NoError(t, err)
+	groups, err := groupCompanions(catsrcs, companions)
+	require.NoError(t, err)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, networkingv1.AddToScheme(scheme))
+	// The schema of the fake client does not match the NetworkPolicy type, so
+	// the apply configurations are deduced from the objects instead
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithTypeConverters(managedfields.NewDeducedTypeConverter()).Build())
+	ctx := context.TODO()
+
+	definitions := map[string]olmv1alpha1.CatalogSource{"house-operators": catsrcs[0]}
+	enabled := New(definitions, map[string]bool{"house-operators": false}, WithCompanions(groups))
+	require.NoError(t, enabled.Ensure(ctx, client, "house-operators"))
+
+	configMap := &corev1.ConfigMap{}
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-config", Namespace: "openshift-marketplace"}, configMap))
+	assert.Equal(t, map[string]string{"key": "value"}, configMap.Data)
+	assert.Equal(t, defaultCatsrcAnnotationValue, configMap.Annotations[defaultCatsrcAnnotationKey])
+	secret := &corev1.Secret{}
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-pull-secret", Namespace: "openshift-marketplace"}, secret))
+	assert.Equal(t, []byte("secret"), secret.Data["token"])
+	networkPolicy := &networkingv1.NetworkPolicy{}
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-operators", Namespace: "openshift-marketplace"}, networkPolicy))
+
+	// Removed fields are restored while fields that are not part of the
+	// definition are kept
+	configMap.Data = map[string]string{"extra": "value"}
+	configMap.Labels["extra"] = "label"
+	require.NoError(t, client.Update(ctx, configMap, crclient.FieldOwner("admin")))
+	require.NoError(t, enabled.Ensure(ctx, client, "house-operators"))
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-config", Namespace: "openshift-marketplace"}, configMap))
+	assert.Equal(t, map[string]string{"key": "value", "extra": "value"}, configMap.Data)
+	assert.Equal(t, "label", configMap.Labels["extra"])
+
+	// Fields changed by another field manager are reported as a conflict
+	// rather than overwritten with the report conflict policy
+	DefaultsConflictPolicy = ConflictPolicyReport
+	configMap.Data["key"] = "changed"
+	require.NoError(t, client.Update(ctx, configMap, crclient.FieldOwner("admin")))
+	result := enabled.EnsureSource(ctx, client, "house-operators")
+	require.NoError(t, result.Err)
+	assert.Equal(t, ActionFieldConflict, result.Action)
+	assert.Equal(t, 1, result.Attempts)
+	require.Len(t, result.Conflicts, 1)
+	assert.Contains(t, result.Conflicts[0], `ConfigMap house-config .data.key (conflict with "admin"`)
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-config", Namespace: "openshift-marketplace"}, configMap))
+	assert.Equal(t, "changed", configMap.Data["key"])
+
+	// They are taken over with the default force conflict policy
+	DefaultsConflictPolicy = ConflictPolicyForce
+	require.NoError(t, enabled.Ensure(ctx, client, "house-operators"))
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-config", Namespace: "openshift-marketplace"}, configMap))
+	assert.Equal(t, "value", configMap.Data["key"])
+	DefaultsConflictPolicy = ConflictPolicyReport
+
+	// Objects with the name of a companion that are not managed by the
+	// operator are left alone
+	delete(configMap.Annotations, defaultCatsrcAnnotationKey)
+	configMap.Data["key"] = "unmanaged"
+	require.NoError(t, client.Update(ctx, configMap, crclient.FieldOwner("admin")))
+	require.NoError(t, enabled.Ensure(ctx, client, "house-operators"))
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-config", Namespace: "openshift-marketplace"}, configMap))
+	assert.Equal(t, "unmanaged", configMap.Data["key"])
+
+	// Unchanged objects are not updated
+	resourceVersion := networkPolicy.ResourceVersion
+	require.NoError(t, enabled.Ensure(ctx, client, "house-operators"))
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-operators", Namespace: "openshift-marketplace"}, networkPolicy))
+	assert.Equal(t, resourceVersion, networkPolicy.ResourceVersion)
+
+	// Disabling the CatalogSource deletes its managed companions
+	disabled := New(definitions, map[string]bool{"house-operators": true}, WithCompanions(groups))
+	require.NoError(t, disabled.Ensure(ctx, client, "house-operators"))
+	err = client.Get(ctx, wrapper.ObjectKey{Name: "house-pull-secret", Namespace: "openshift-marketplace"}, secret)
+	assert.True(t, k8sErrors.IsNotFound(err))
+	err = client.Get(ctx, wrapper.ObjectKey{Name: "house-operators", Namespace: "openshift-marketplace"}, networkPolicy)
+	assert.True(t, k8sErrors.IsNotFound(err))
+	// Objects no longer managed by the operator are left alone
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "house-config", Namespace: "openshift-marketplace"}, configMap))
+}
+
+func TestClusterVersionConstraints(t *testing.T) {
+	resetState(t)
+	withConstraints := func(name, image string, annotations ...string) string {
+		definition := fmt.Sprintf(testCatsrcDefinition, name, image)
+		if len(annotations) == 0 {
+			return definition
+		}
+		return strings.Replace(definition, "metadata:\n", "metadata:\n  annotations:\n    "+strings.Join(annotations, "\n    ")+"\n", 1)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"01_unconstrained.yaml": withConstraints("unconstrained-operators", "registry.io/unconstrained:latest"),
+		"02_old.yaml":           withConstraints("versioned-operators", "registry.io/old:latest", `operatorframework.io/maxClusterVersion: "4.19"`),
+		"03_new.yaml":           withConstraints("versioned-operators", "registry.io/new:latest", `operatorframework.io/minClusterVersion: "4.20"`, `operatorframework.io/maxClusterVersion: "4.22.9"`),
+		"04_future.yaml":        withConstraints("future-operators", "registry.io/future:latest", `operatorframework.io/minClusterVersion: "5.0"`),
+		"05_invalid.yaml":       withConstraints("invalid-operators", "registry.io/invalid:latest", `operatorframework.io/minClusterVersion: "four"`),
+		"06_inverted.yaml":      withConstraints("inverted-operators", "registry.io/inverted:latest", `operatorframework.io/minClusterVersion: "4.22"`, `operatorframework.io/maxClusterVersion: "4.20"`),
+	}
+	for fileName, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fileName), []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name              string
+		releaseVersion    string
+		wantImages        map[string]string
+		wantNotApplicable []string
+	}{
+		{
+			name:           "unknown release version",
+			releaseVersion: "",
+			// Constraints are not evaluated, so the duplicate is rejected
+			wantImages: map[string]string{
+				"unconstrained-operators": "registry.io/unconstrained:latest",
+				"versioned-operators":     "registry.io/old:latest",
+				"future-operators":        "registry.io/future:latest",
 			},
-			imageTagOverride: "v4.23",
-			wantImage:        "registry.io/catalog@sha256:abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890",
-			wantErr:          false,
 		},
 		{
-			name: "image with port",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io:5000/catalog:v5.0",
-				},
+			name:           "below the minimum",
+			releaseVersion: "4.19.12",
+			wantImages: map[string]string{
+				"unconstrained-operators": "registry.io/unconstrained:latest",
+				"versioned-operators":     "registry.io/old:latest",
 			},
-			imageTagOverride: "v4.23",
-			wantImage:        "registry.io:5000/catalog:v4.23",
-			wantErr:          false,
+			wantNotApplicable: []string{"future-operators"},
 		},
 		{
-			name: "image with nested path",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "registry.io/org/team/catalog:v5.0",
-				},
+			name:           "bounds are inclusive and ignore the patch version",
+			releaseVersion: "4.22.13-rc.0",
+			wantImages: map[string]string{
+				"unconstrained-operators": "registry.io/unconstrained:latest",
+				"versioned-operators":     "registry.io/new:latest",
 			},
-			imageTagOverride: "v4.23",
-			wantImage:        "registry.io/org/team/catalog:v4.23",
-			wantErr:          false,
+			wantNotApplicable: []string{"future-operators"},
 		},
 		{
-			name: "invalid image reference",
-			catsrc: &olmv1alpha1.CatalogSource{
-				ObjectMeta: metav1.ObjectMeta{Name: "test"},
-				Spec: olmv1alpha1.CatalogSourceSpec{
-					Image: "not:::valid",
-				},
+			name:           "above the maximum",
+			releaseVersion: "5.1.0",
+			wantImages: map[string]string{
+				"unconstrained-operators": "registry.io/unconstrained:latest",
+				"future-operators":        "registry.io/future:latest",
 			},
-			imageTagOverride: "v4.23",
-			wantImage:        "not:::valid",
-			wantErr:          true,
+			wantNotApplicable: []string{"versioned-operators"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := overrideImageTag(tt.catsrc, tt.imageTagOverride)
-			if tt.wantErr {
-				require.Error(t, err)
-				assert.Contains(t, err.Error(), "invalid image")
+			releaseVersion, err := ParseReleaseVersion(tt.releaseVersion)
+			require.NoError(t, err)
+
+			set, err := populateDefsConfig(dir, NewTemplateContext("", releaseVersion, nil))
+			require.NoError(t, err)
+
+			images := map[string]string{}
+			for name, catsrc := range set.catsrcDefinitions {
+				images[name] = catsrc.Spec.Image
+			}
+			assert.Equal(t, tt.wantImages, images)
+
+			notApplicable := []string{}
+			for name := range set.notApplicable {
+				notApplicable = append(notApplicable, name)
+			}
+			assert.ElementsMatch(t, tt.wantNotApplicable, notApplicable)
+
+			errs := map[string]string{}
+			for _, loadError := range set.loadErrors {
+				errs[loadError.File] = loadError.Err.Error()
+			}
+			assert.Contains(t, errs["05_invalid.yaml"], `invalid operatorframework.io/minClusterVersion "four"`)
+			assert.Contains(t, errs["06_inverted.yaml"], "greater than its")
+		})
+	}
+
+	releaseVersion, err := ParseReleaseVersion("4.18.0")
+	require.NoError(t, err)
+	Dir = dir
+	defer func() {
+		Dir = ""
+		require.NoError(t, PopulateGlobals(TemplateContext{}))
+	}()
+	require.NoError(t, PopulateGlobals(NewTemplateContext("", releaseVersion, nil)))
+	reason, notApplicable := GetNotApplicableReason("future-operators")
+	assert.True(t, notApplicable)
+	assert.Equal(t, "Requires a cluster version of at least 5.0, the cluster is at 4.18.0", reason)
+	_, notApplicable = GetNotApplicableReason("versioned-operators")
+	assert.False(t, notApplicable)
+}
+
+func TestRenderDefinitions(t *testing.T) {
+	resetState(t)
+	releaseVersion, err := ParseReleaseVersion("4.23.1")
+	require.NoError(t, err)
+	newInfrastructure := func(topology configv1.TopologyMode) *configv1.Infrastructure {
+		return &configv1.Infrastructure{
+			Status: configv1.InfrastructureStatus{
+				ControlPlaneTopology: topology,
+				PlatformStatus:       &configv1.PlatformStatus{Type: configv1.AWSPlatformType},
+			},
+		}
+	}
+
+	// The shipped defaults are templates
+	standard, err := populateDefsConfig("../../defaults", NewTemplateContext("openshift-marketplace", releaseVersion, newInfrastructure(configv1.HighlyAvailableTopologyMode)))
+	require.NoError(t, err)
+	require.Empty(t, standard.loadErrors)
+	require.Len(t, standard.catsrcDefinitions, 3)
+	for _, catsrc := range standard.catsrcDefinitions {
+		assert.Equal(t, "openshift-marketplace", catsrc.Namespace)
+		assert.Equal(t, `{{if and (eq .Major 4) (eq .Tag "v5.0")}}v{{.Major}}.{{.Minor}}{{else}}{{.Tag}}{{end}}`, catsrc.Annotations[ImageTagTemplateAnnotationKey])
+		assert.True(t, strings.HasSuffix(catsrc.Spec.Image, ":v4.23"), catsrc.Spec.Image)
+		assert.Contains(t, catsrc.Spec.GrpcPodConfig.NodeSelector, "node-role.kubernetes.io/master")
+		assert.Len(t, catsrc.Spec.GrpcPodConfig.Tolerations, 3)
+	}
+
+	hosted, err := populateDefsConfig("../../defaults", NewTemplateContext("marketplace", releaseVersion, newInfrastructure(configv1.ExternalTopologyMode)))
+	require.NoError(t, err)
+	require.Empty(t, hosted.loadErrors)
+	for _, catsrc := range hosted.catsrcDefinitions {
+		assert.Equal(t, "marketplace", catsrc.Namespace)
+		assert.Equal(t, map[string]string{"kubernetes.io/os": "linux"}, catsrc.Spec.GrpcPodConfig.NodeSelector)
+		assert.Len(t, catsrc.Spec.GrpcPodConfig.Tolerations, 2)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"01_platform.yaml":  fmt.Sprintf(testCatsrcDefinition, "platform-operators", "registry.io/platform:{{ .ReleaseVersion }}-{{ .Platform }}-{{ .ControlPlaneTopology }}"),
+		"02_namespace.yaml": strings.Replace(fmt.Sprintf(testCatsrcDefinition, "namespace-operators", "registry.io/namespace:latest"), "  namespace: openshift-marketplace\n", "", 1),
+		"03_other.yaml":     strings.Replace(fmt.Sprintf(testCatsrcDefinition, "other-operators", "registry.io/other:latest"), "openshift-marketplace", "other", 1),
+		"04_missing.yaml":   fmt.Sprintf(testCatsrcDefinition, "missing-operators", "registry.io/missing:{{ .Missing }}"),
+		"05_invalid.yaml":   fmt.Sprintf(testCatsrcDefinition, "invalid-operators", "registry.io/invalid:{{ .Major"),
+	}
+	for fileName, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fileName), []byte(content), 0644))
+	}
+
+	set, err := populateDefsConfig(dir, NewTemplateContext("openshift-marketplace", releaseVersion, newInfrastructure(configv1.SingleReplicaTopologyMode)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"namespace-operators", "platform-operators"}, set.names())
+	assert.Equal(t, "registry.io/platform:4.23.1-AWS-SingleReplica", set.catsrcDefinitions["platform-operators"].Spec.Image)
+	// Definitions that omit their namespace are placed in the watch namespace
+	assert.Equal(t, "openshift-marketplace", set.catsrcDefinitions["namespace-operators"].Namespace)
+
+	errs := map[string]string{}
+	for _, loadError := range set.loadErrors {
+		errs[loadError.File] = loadError.Err.Error()
+	}
+	assert.Len(t, errs, 3)
+	assert.Contains(t, errs["03_other.yaml"], "must be in the openshift-marketplace namespace watched by the operator, not other")
+	assert.Contains(t, errs["04_missing.yaml"], "failed to render template")
+	assert.Contains(t, errs["05_invalid.yaml"], "invalid template")
+}
+
+func TestEnsureCatsrcPresent(t *testing.T) {
+	resetState(t)
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+	recorder := events.NewFakeRecorder(10)
+	d := New(definitions, map[string]bool{"redhat-operators": false}, WithEventRecorder(recorder, nil))
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithReturnManagedFields().Build())
+	ctx := context.TODO()
+	key := wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}
+
+	require.NoError(t, d.Ensure(ctx, client, "redhat-operators"))
+	assert.Equal(t, "Normal DefaultCatalogSourceCreated Created the default CatalogSource", <-recorder.Events)
+	cluster := &olmv1alpha1.CatalogSource{}
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:v4.23", cluster.Spec.Image)
+	assert.Equal(t, defaultCatsrcAnnotationValue, cluster.Annotations[defaultCatsrcAnnotationKey])
+	require.NotEmpty(t, cluster.ManagedFields)
+	assert.Equal(t, FieldManager, cluster.ManagedFields[0].Manager)
+	assert.Equal(t, metav1.ManagedFieldsOperationApply, cluster.ManagedFields[0].Operation)
+
+	// Fields the definition does not set are left alone and do not cause updates
+	cluster.Spec.Description = "set by an admin"
+	cluster.ManagedFields = nil
+	require.NoError(t, client.Update(ctx, cluster, crclient.FieldOwner("admin")))
+	require.NoError(t, client.Get(ctx, key, cluster))
+	resourceVersion := cluster.ResourceVersion
+	require.NoError(t, d.Ensure(ctx, client, "redhat-operators"))
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, resourceVersion, cluster.ResourceVersion)
+	assert.Equal(t, "set by an admin", cluster.Spec.Description)
+
+	// Fields that only differ in case are not considered drifted
+	cluster.Spec.SourceType = "GRPC"
+	cluster.Spec.Image = "REGISTRY.io/redhat:V4.23"
+	cluster.ManagedFields = nil
+	require.NoError(t, client.Update(ctx, cluster, crclient.FieldOwner("admin")))
+	require.NoError(t, client.Get(ctx, key, cluster))
+	resourceVersion = cluster.ResourceVersion
+	result := d.EnsureSource(ctx, client, "redhat-operators")
+	require.NoError(t, result.Err)
+	assert.Equal(t, ActionNone, result.Action)
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, resourceVersion, cluster.ResourceVersion)
+	assert.Equal(t, "REGISTRY.io/redhat:V4.23", cluster.Spec.Image)
+	spec := catsrcs[0].Spec.DeepCopy()
+	spec.Description = cluster.Spec.Description
+	assert.True(t, AreCatsrcSpecsEqual(spec, &cluster.Spec))
+	cluster.Spec.SourceType = "grpc"
+	cluster.Spec.Image = "registry.io/redhat:v4.23"
+	cluster.ManagedFields = nil
+	require.NoError(t, client.Update(ctx, cluster, crclient.FieldOwner(FieldManager)))
+
+	// Conflicting changes to fields the definition sets are reported rather
+	// than taken over with the report conflict policy
+	DefaultsConflictPolicy = ConflictPolicyReport
+	cluster.Spec.Image = "registry.io/redhat:changed"
+	cluster.ManagedFields = nil
+	require.NoError(t, client.Update(ctx, cluster, crclient.FieldOwner("admin")))
+	restores := metrics.DefaultCatalogRestores.WithLabelValues("redhat-operators", "spec.image")
+	restoresBefore := counterValue(t, restores)
+	result = d.EnsureSource(ctx, client, "redhat-operators")
+	require.NoError(t, result.Err)
+	assert.Equal(t, ActionFieldConflict, result.Action)
+	require.Len(t, result.Conflicts, 1)
+	assert.Contains(t, result.Conflicts[0], `.spec.image (conflict with "admin"`)
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:changed", cluster.Spec.Image)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning DefaultCatalogSourceFieldConflict The default definition is not enforced: fields conflict with other field managers")
+
+	// They are taken over with the default force conflict policy
+	DefaultsConflictPolicy = ConflictPolicyForce
+	require.NoError(t, d.Ensure(ctx, client, "redhat-operators"))
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:v4.23", cluster.Spec.Image)
+	assert.Equal(t, "set by an admin", cluster.Spec.Description)
+
+	// The restored fields are reported along with who changed them
+	assert.Equal(t, restoresBefore+1, counterValue(t, restores))
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning DefaultCatalogSourceRestored Restored fields changed on the cluster: spec.image (by admin)", <-recorder.Events)
+
+	// Changes to the definition are applied without being reported
+	catsrcs, _, err = decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.24")))
+	require.NoError(t, err)
+	definitions["redhat-operators"] = catsrcs[0]
+	require.NoError(t, d.Ensure(ctx, client, "redhat-operators"))
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:v4.24", cluster.Spec.Image)
+	assert.Equal(t, "Normal DefaultCatalogSourceUpdated Updated the CatalogSource to its default definition", <-recorder.Events)
+	assert.Equal(t, restoresBefore+1, counterValue(t, restores))
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	require.NoError(t, counter.Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func TestComputeDrift(t *testing.T) {
+	resetState(t)
+	desired := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "redhat-operators",
+			"annotations": map[string]interface{}{"operatorframework.io/managed-by": "marketplace-operator"},
+		},
+		"spec": map[string]interface{}{
+			"image":    "registry.io/redhat:v4.23",
+			"priority": int64(-100),
+			"grpcPodConfig": map[string]interface{}{
+				"nodeSelector": map[string]interface{}{"kubernetes.io/os": "linux"},
+			},
+		},
+	}}
+	cluster := &olmv1alpha1.CatalogSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "redhat-operators",
+			Annotations: map[string]string{
+				"operatorframework.io/managed-by": "marketplace-operator",
+				OriginalImageAnnotationKey:        "registry.io/redhat:v4.22",
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: FieldManager, Operation: metav1.ManagedFieldsOperationApply, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:image":{},"f:priority":{}}}`)}},
+				{Manager: "gitops", Operation: metav1.ManagedFieldsOperationUpdate, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:grpcPodConfig":{"f:nodeSelector":{"f:kubernetes.io/os":{}}}}}`)}},
+			},
+		},
+		Spec: olmv1alpha1.CatalogSourceSpec{
+			Image:         "registry.io/redhat:v4.22",
+			GrpcPodConfig: &olmv1alpha1.GrpcPodConfig{NodeSelector: map[string]string{"kubernetes.io/os": "windows"}},
+		},
+	}
+
+	drift, err := computeDrift(desired, cluster)
+	require.NoError(t, err)
+	var reported []string
+	for _, field := range drift {
+		reported = append(reported, field.String())
+	}
+	// spec.image is only owned by the operator, so the definition changed
+	assert.Equal(t, []string{
+		"metadata.annotations.operatorframework.io/original-image (by unknown)",
+		"spec.grpcPodConfig.nodeSelector.kubernetes.io/os (by gitops)",
+	}, reported)
+}
+
+func TestOverrides(t *testing.T) {
+	resetState(t)
+	_, err := ParseOverrides(`{"redhat-operators": {"priority": 10, "unknown": true}}`)
+	assert.Error(t, err)
+	_, err = ParseOverrides(`not json`)
+	assert.Error(t, err)
+	overrides, err := ParseOverrides("")
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	overrides, err = ParseOverrides(`{
+		"redhat-operators": {
+			"priority": 10,
+			"pollInterval": "30m",
+			"nodeSelector": {"node-role.kubernetes.io/infra": ""},
+			"tolerations": [{"key": "node-role.kubernetes.io/infra", "operator": "Exists", "effect": "NoSchedule"}],
+			"memoryTarget": "100Mi"
+		},
+		"community-operators": {"pollInterval": "-5m", "priority": 5}
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"memoryTarget", "nodeSelector", "pollInterval", "priority", "tolerations"}, overrides["redhat-operators"].Fields())
+
+	definitions := make(map[string]olmv1alpha1.CatalogSource)
+	for _, name := range []string{"redhat-operators", "community-operators"} {
+		catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, name, "registry.io/"+name+":v4.23")))
+		require.NoError(t, err)
+		definitions[name] = catsrcs[0]
+	}
+	config := map[string]bool{"redhat-operators": false, "community-operators": false}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build())
+	ctx := context.TODO()
+	d := New(definitions, config, WithOverrides(overrides))
+
+	// A valid override is merged onto the definition
+	require.NoError(t, d.Ensure(ctx, client, "redhat-operators"))
+	cluster := &olmv1alpha1.CatalogSource{}
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}, cluster))
+	assert.Equal(t, 10, cluster.Spec.Priority)
+	require.NotNil(t, cluster.Spec.UpdateStrategy)
+	assert.Equal(t, "30m", cluster.Spec.UpdateStrategy.RegistryPoll.RawInterval)
+	require.NotNil(t, cluster.Spec.GrpcPodConfig)
+	assert.Equal(t, map[string]string{"node-role.kubernetes.io/infra": ""}, cluster.Spec.GrpcPodConfig.NodeSelector)
+	assert.Len(t, cluster.Spec.GrpcPodConfig.Tolerations, 1)
+	assert.Equal(t, "100Mi", cluster.Spec.GrpcPodConfig.MemoryTarget.String())
+	assert.Nil(t, definitions["redhat-operators"].Spec.GrpcPodConfig, "the definition must not be modified")
+	assert.Equal(t, "memoryTarget,nodeSelector,pollInterval,priority,tolerations", cluster.Annotations[OverriddenFieldsAnnotationKey])
+
+	// Removing the override restores the definition
+	require.NoError(t, New(definitions, config).Ensure(ctx, client, "redhat-operators"))
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}, cluster))
+	assert.Equal(t, 0, cluster.Spec.Priority)
+	assert.Nil(t, cluster.Spec.UpdateStrategy)
+	assert.Nil(t, cluster.Spec.GrpcPodConfig)
+	assert.NotContains(t, cluster.Annotations, OverriddenFieldsAnnotationKey)
+
+	// An invalid override is reported and not applied at all
+	err = d.Ensure(ctx, client, "community-operators")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pollInterval")
+	require.NoError(t, client.Get(ctx, wrapper.ObjectKey{Name: "community-operators", Namespace: "openshift-marketplace"}, cluster))
+	assert.Equal(t, 0, cluster.Spec.Priority)
+	assert.Nil(t, cluster.Spec.UpdateStrategy)
+}
+
+func TestValidateToleration(t *testing.T) {
+	resetState(t)
+	seconds := int64(60)
+	for _, tt := range []struct {
+		name       string
+		toleration corev1.Toleration
+		valid      bool
+	}{
+		{"equal", corev1.Toleration{Key: "key", Value: "value", Effect: corev1.TaintEffectNoSchedule}, true},
+		{"exists without key", corev1.Toleration{Operator: corev1.TolerationOpExists}, true},
+		{"no execute with seconds", corev1.Toleration{Key: "key", Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &seconds}, true},
+		{"equal without key", corev1.Toleration{Value: "value"}, false},
+		{"exists with value", corev1.Toleration{Key: "key", Operator: corev1.TolerationOpExists, Value: "value"}, false},
+		{"unknown operator", corev1.Toleration{Key: "key", Operator: "In"}, false},
+		{"unknown effect", corev1.Toleration{Key: "key", Effect: "Evict"}, false},
+		{"seconds without no execute", corev1.Toleration{Key: "key", Effect: corev1.TaintEffectNoSchedule, TolerationSeconds: &seconds}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateToleration(tt.toleration)
+			if tt.valid {
+				assert.NoError(t, err)
 			} else {
-				require.NoError(t, err)
-				if tt.catsrc != nil {
-					assert.Equal(t, tt.wantImage, tt.catsrc.Spec.Image)
-				}
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	resetState(t)
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+	hub := &configv1.OperatorHub{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build())
+	ctx := context.TODO()
+	key := wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}
+
+	ensure := func(disabled bool, opts ...Option) ([]string, error) {
+		recorder := events.NewFakeRecorder(10)
+		opts = append(opts, WithEventRecorder(recorder, hub))
+		err := New(definitions, map[string]bool{"redhat-operators": disabled}, opts...).Ensure(ctx, client, "redhat-operators")
+		close(recorder.Events)
+		var recorded []string
+		for event := range recorder.Events {
+			recorded = append(recorded, event)
+		}
+		return recorded, err
+	}
+
+	// Every event is recorded on the CatalogSource and the OperatorHub
+	recorded, err := ensure(false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Normal DefaultCatalogSourceCreated Created the default CatalogSource",
+		"Normal DefaultCatalogSourceCreated CatalogSource redhat-operators: Created the default CatalogSource",
+	}, recorded)
+
+	recorded, err = ensure(true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Normal DefaultCatalogSourceDeleted Deleted the CatalogSource as it is disabled in the OperatorHub configuration",
+		"Normal DefaultCatalogSourceDeleted CatalogSource redhat-operators: Deleted the CatalogSource as it is disabled in the OperatorHub configuration",
+	}, recorded)
+
+	// A CatalogSource that is not managed by the operator is left in place
+	unmanaged := definitions["redhat-operators"]
+	require.NoError(t, client.Create(ctx, unmanaged.DeepCopy()))
+	recorded, err = ensure(true)
+	require.NoError(t, err)
+	require.Len(t, recorded, 2)
+	assert.True(t, strings.HasPrefix(recorded[0], "Warning DefaultCatalogSourceSkipped "))
+	require.NoError(t, client.Get(ctx, key, &olmv1alpha1.CatalogSource{}))
+	// and it is only reported again once its state changes
+	recorded, err = ensure(true)
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+
+	// Errors are recorded after the other events
+	recorded, err = ensure(false, WithOverrides(map[string]Override{"redhat-operators": {PollInterval: "never"}}))
+	require.Error(t, err)
+	require.Len(t, recorded, 4)
+	assert.True(t, strings.HasPrefix(recorded[2], "Warning DefaultCatalogSourceFailed "))
+	assert.True(t, strings.HasPrefix(recorded[3], "Warning DefaultCatalogSourceFailed CatalogSource redhat-operators: "))
+}
+
+func TestDryRun(t *testing.T) {
+	resetState(t)
+	dryRun, err := ParseDryRun("")
+	require.NoError(t, err)
+	assert.False(t, dryRun)
+	dryRun, err = ParseDryRun("true")
+	require.NoError(t, err)
+	assert.True(t, dryRun)
+	// A mistyped annotation fails closed
+	dryRun, err = ParseDryRun("yes please")
+	assert.Error(t, err)
+	assert.True(t, dryRun)
+
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build())
+	ctx := context.TODO()
+	key := wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}
+	pending := func(action Action) float64 {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.DefaultCatalogDryRunActions.WithLabelValues("redhat-operators", string(action)).Write(m))
+		return m.GetGauge().GetValue()
+	}
+
+	// Nothing is created in dry-run mode. The CatalogSource does not exist,
+	// so the event is only recorded on the OperatorHub, and only once.
+	recorder := events.NewFakeRecorder(10)
+	hub := &configv1.OperatorHub{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+	for i := 0; i < 2; i++ {
+		result := New(definitions, map[string]bool{"redhat-operators": false}, WithDryRun(true), WithEventRecorder(recorder, hub)).EnsureAll(ctx, client)
+		assert.Equal(t, Result{Action: ActionCreate, DryRun: true, Attempts: 1}, result["redhat-operators"])
+	}
+	assert.True(t, k8sErrors.IsNotFound(client.Get(ctx, key, &olmv1alpha1.CatalogSource{})))
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Normal DefaultCatalogSourceDryRun CatalogSource redhat-operators: Dry run: would create the default CatalogSource", <-recorder.Events)
+	assert.Equal(t, float64(1), pending(ActionCreate))
+
+	// Changes on the cluster are not restored
+	result := New(definitions, map[string]bool{"redhat-operators": false}).EnsureAll(ctx, client)
+	assert.Equal(t, Result{Action: ActionCreate, Attempts: 1}, result["redhat-operators"])
+	assert.Equal(t, float64(0), pending(ActionCreate), "the pending actions are cleared when the dry-run mode is disabled")
+	cluster := &olmv1alpha1.CatalogSource{}
+	require.NoError(t, client.Get(ctx, key, cluster))
+	cluster.Spec.Image = "registry.io/redhat:changed"
+	require.NoError(t, client.Update(ctx, cluster))
+
+	DryRun = true
+	defer func() { DryRun = false }()
+	result = New(definitions, map[string]bool{"redhat-operators": false}).EnsureAll(ctx, client)
+	assert.Equal(t, ActionRestore, result["redhat-operators"].Action)
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:changed", cluster.Spec.Image)
+
+	// Disabled CatalogSources are not deleted
+	result = New(definitions, map[string]bool{"redhat-operators": true}).EnsureAll(ctx, client)
+	assert.Equal(t, Result{Action: ActionDelete, DryRun: true, Attempts: 1}, result["redhat-operators"])
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, float64(1), pending(ActionDelete))
+	assert.Equal(t, float64(0), pending(ActionRestore))
+}
+
+func TestUnmanaged(t *testing.T) {
+	resetState(t)
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build())
+	ctx := context.TODO()
+	key := wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}
+
+	require.NoError(t, New(definitions, map[string]bool{"redhat-operators": false}).Ensure(ctx, client, "redhat-operators"))
+	cluster := &olmv1alpha1.CatalogSource{}
+	require.NoError(t, client.Get(ctx, key, cluster))
+	cluster.Annotations[UnmanagedAnnotationKey] = "true"
+	cluster.Spec.Image = "registry.io/redhat:hotfix"
+	require.NoError(t, client.Update(ctx, cluster))
+
+	// The hot fix is kept, even if the CatalogSource is disabled
+	for _, disabled := range []bool{false, true} {
+		result := New(definitions, map[string]bool{"redhat-operators": disabled}).EnsureAll(ctx, client)
+		assert.Equal(t, Result{Action: ActionUnmanaged, Attempts: 1}, result["redhat-operators"])
+		require.NoError(t, client.Get(ctx, key, cluster))
+		assert.Equal(t, "registry.io/redhat:hotfix", cluster.Spec.Image)
+		assert.True(t, unmanagedSources["redhat-operators"])
+	}
+
+	// The definition is enforced again once the annotation is removed, the
+	// hot fix is taken over with the default force conflict policy
+	delete(cluster.Annotations, UnmanagedAnnotationKey)
+	require.NoError(t, client.Update(ctx, cluster))
+	result := New(definitions, map[string]bool{"redhat-operators": false}).EnsureAll(ctx, client)
+	assert.Equal(t, Result{Action: ActionRestore, Attempts: 1}, result["redhat-operators"])
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:v4.23", cluster.Spec.Image)
+	assert.False(t, unmanagedSources["redhat-operators"])
+}
+
+func TestSweepOrphans(t *testing.T) {
+	resetState(t)
+	var policy OrphanPolicy
+	assert.Error(t, policy.Set("remove"))
+	require.NoError(t, policy.Set("delete"))
+	assert.Equal(t, OrphanPolicyDelete, policy)
+
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+	managed := map[string]string{defaultCatsrcAnnotationKey: defaultCatsrcAnnotationValue}
+	orphan := &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: "old-operators", Namespace: "openshift-marketplace", Annotations: managed}}
+	custom := &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: "custom-operators", Namespace: "openshift-marketplace"}}
+	current := &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators", Namespace: "openshift-marketplace", Annotations: managed}}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(orphan, custom, current).Build())
+	ctx := context.TODO()
+	d := New(definitions, map[string]bool{"redhat-operators": false})
+	present := func(name string) bool {
+		err := client.Get(ctx, wrapper.ObjectKey{Name: name, Namespace: "openshift-marketplace"}, &olmv1alpha1.CatalogSource{})
+		return err == nil
+	}
+	defer func() { OrphanedDefaultsPolicy = OrphanPolicyWarn }()
+	globalsLock.Lock()
+	dirDefinitionsLoaded = true
+	globalsLock.Unlock()
+
+	// Orphans are only reported by default
+	orphans, err := d.SweepOrphans(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, "old-operators", orphans[0].Name)
+	assert.Equal(t, ActionNone, orphans[0].Action)
+	assert.Contains(t, orphans[0].Reason, "policy is warn")
+	assert.True(t, present("old-operators"))
+
+	// Orphans are not deleted in dry-run mode or while definitions fail to load
+	OrphanedDefaultsPolicy = OrphanPolicyDelete
+	orphans, err = New(definitions, map[string]bool{}, WithDryRun(true)).SweepOrphans(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, Orphan{Name: "old-operators", Action: ActionDelete, DryRun: true}, orphans[0])
+	assert.True(t, present("old-operators"))
+
+	globalLoadErrors = []LoadError{{File: "broken.yaml", Err: fmt.Errorf("broken")}}
+	orphans, err = d.SweepOrphans(ctx, client)
+	globalLoadErrors = nil
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Contains(t, orphans[0].Reason, "failed to load")
+	assert.True(t, present("old-operators"))
+
+	orphans, err = d.SweepOrphans(ctx, client)
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	assert.False(t, present("old-operators"))
+	assert.True(t, present("custom-operators"), "CatalogSources not managed by the operator are never orphans")
+	assert.True(t, present("redhat-operators"))
+}
+
+func TestSweepOrphansBeforeConfigMapDefinitions(t *testing.T) {
+	resetState(t)
+	dir := t.TempDir()
+	Dir = dir
+	defer func() {
+		Dir = ""
+		SetConfigMapDefinitions(nil)
+	}()
+	defer func() { OrphanedDefaultsPolicy = OrphanPolicyWarn }()
+	OrphanedDefaultsPolicy = OrphanPolicyDelete
+
+	writeDefinition(t, dir, "01_redhat.yaml", "redhat-operators", "registry.io/redhat:v4.23")
+	require.NoError(t, PopulateGlobals(TemplateContext{WatchNamespace: "openshift-marketplace"}))
+	ExpectConfigMapDefinitions()
+
+	managed := map[string]string{defaultCatsrcAnnotationKey: defaultCatsrcAnnotationValue}
+	house := &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: "house-operators", Namespace: "openshift-marketplace", Annotations: managed}}
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(house).Build())
+	ctx := context.TODO()
+	sweep := func() []Orphan {
+		definitions, config := GetGlobals()
+		orphans, err := New(definitions, config).SweepOrphans(ctx, client)
+		require.NoError(t, err)
+		return orphans
+	}
+	present := func() bool {
+		return client.Get(ctx, wrapper.ObjectKey{Name: "house-operators", Namespace: "openshift-marketplace"}, &olmv1alpha1.CatalogSource{}) == nil
+	}
+
+	// A CatalogSource defined in a ConfigMap is not deleted before the
+	// ConfigMaps are loaded for the first time
+	orphans := sweep()
+	require.Len(t, orphans, 1)
+	assert.Equal(t, ActionNone, orphans[0].Action)
+	assert.Contains(t, orphans[0].Reason, "have not all been loaded yet")
+	assert.True(t, present())
+
+	// Once they are, it is no longer an orphan
+	SetConfigMapDefinitions([]corev1.ConfigMap{{
+		ObjectMeta: metav1.ObjectMeta{Name: "house", Namespace: "openshift-marketplace"},
+		Data:       map[string]string{"house.yaml": fmt.Sprintf(testCatsrcDefinition, "house-operators", "registry.io/house:latest")},
+	}})
+	assert.Empty(t, sweep())
+	assert.True(t, present())
+
+	// and it is deleted once its ConfigMap is gone
+	SetConfigMapDefinitions(nil)
+	assert.Empty(t, sweep())
+	assert.False(t, present())
+}
+
+func TestAdoptionPolicy(t *testing.T) {
+	resetState(t)
+	var policy AdoptionPolicy
+	assert.Error(t, policy.Set("sometimes"))
+	require.NoError(t, policy.Set("only-if-spec-matches"))
+	assert.Equal(t, AdoptionPolicyIfSpecMatches, policy)
+
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+	config := map[string]bool{"redhat-operators": false}
+	key := wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}
+	defer func() { DefaultsAdoptionPolicy = AdoptionPolicyAlways }()
+
+	for _, tt := range []struct {
+		policy  AdoptionPolicy
+		image   string
+		action  Action
+		adopted bool
+	}{
+		{AdoptionPolicyAlways, "registry.io/custom:latest", ActionAdopt, true},
+		{AdoptionPolicyNever, "registry.io/redhat:v4.23", ActionConflict, false},
+		{AdoptionPolicyIfSpecMatches, "registry.io/redhat:v4.23", ActionAdopt, true},
+		{AdoptionPolicyIfSpecMatches, "registry.io/custom:latest", ActionConflict, false},
+	} {
+		t.Run(fmt.Sprintf("%s with %s", tt.policy, tt.image), func(t *testing.T) {
+			DefaultsAdoptionPolicy = tt.policy
+			existing := &olmv1alpha1.CatalogSource{
+				ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators", Namespace: "openshift-marketplace"},
+				Spec:       olmv1alpha1.CatalogSourceSpec{SourceType: olmv1alpha1.SourceTypeGrpc, Image: tt.image},
+			}
+			scheme := runtime.NewScheme()
+			require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+			client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build())
+			ctx := context.TODO()
+
+			result := New(definitions, config).EnsureAll(ctx, client)
+			assert.Equal(t, Result{Action: tt.action, Attempts: 1}, result["redhat-operators"])
+			cluster := &olmv1alpha1.CatalogSource{}
+			require.NoError(t, client.Get(ctx, key, cluster))
+			if tt.adopted {
+				assert.Equal(t, defaultCatsrcAnnotationValue, cluster.Annotations[defaultCatsrcAnnotationKey])
+				assert.Equal(t, "registry.io/redhat:v4.23", cluster.Spec.Image)
+			} else {
+				assert.NotContains(t, cluster.Annotations, defaultCatsrcAnnotationKey)
+				assert.Equal(t, tt.image, cluster.Spec.Image)
 			}
 		})
 	}
 }
+
+func TestClassifyError(t *testing.T) {
+	resetState(t)
+	resource := schema.GroupResource{Group: "operators.coreos.com", Resource: "catalogsources"}
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{nil, ErrorClassNone},
+		{fmt.Errorf("failed to apply: %w", k8sErrors.NewConflict(resource, "redhat-operators", errors.New("changed"))), ErrorClassConflict},
+		{k8sErrors.NewTimeoutError("slow", 1), ErrorClassTimeout},
+		{context.DeadlineExceeded, ErrorClassTimeout},
+		{k8sErrors.NewForbidden(resource, "redhat-operators", errors.New(`admission webhook "validate.example.com" denied the request`)), ErrorClassDenied},
+		{k8sErrors.NewInternalError(errors.New(`failed calling webhook "validate.example.com": connection refused`)), ErrorClassTransient},
+		{k8sErrors.NewApplyConflict([]metav1.StatusCause{{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.image", Message: `conflict with "admin"`}}, "conflict"), ErrorClassPermanent},
+		{&fieldConflictError{conflicts: []string{`.spec.image (conflict with "admin")`}}, ErrorClassPermanent},
+		{k8sErrors.NewServiceUnavailable("unavailable"), ErrorClassTransient},
+		{errors.New("connection refused"), ErrorClassTransient},
+		{k8sErrors.NewForbidden(resource, "redhat-operators", errors.New("no RBAC")), ErrorClassPermanent},
+		{k8sErrors.NewBadRequest("bad"), ErrorClassPermanent},
+		{context.Canceled, ErrorClassPermanent},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.class, classifyError(tt.err), "%v", tt.err)
+	}
+}
+
+func TestEnsureAllRetries(t *testing.T) {
+	resetState(t)
+	definitions := make(map[string]olmv1alpha1.CatalogSource)
+	config := make(map[string]bool)
+	for _, name := range []string{"certified-operators", "community-operators", "conflicted-operators", "denied-operators", "redhat-operators"} {
+		catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, name, "registry.io/"+name+":v4.23")))
+		require.NoError(t, err)
+		definitions[name] = catsrcs[0]
+		config[name] = false
+	}
+
+	resource := schema.GroupResource{Group: "operators.coreos.com", Resource: "catalogsources"}
+	var lock sync.Mutex
+	applies := make(map[string]int)
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(ctx context.Context, c crclient.WithWatch, obj runtime.ApplyConfiguration, opts ...crclient.ApplyOption) error {
+			name := obj.(interface{ GetName() string }).GetName()
+			lock.Lock()
+			applies[name]++
+			attempt := applies[name]
+			lock.Unlock()
+			switch {
+			case name == "community-operators" && attempt <= 2, name == "conflicted-operators":
+				return k8sErrors.NewConflict(resource, name, errors.New("changed"))
+			case name == "certified-operators" && attempt <= 1:
+				return k8sErrors.NewServiceUnavailable("unavailable")
+			case name == "denied-operators":
+				return k8sErrors.NewForbidden(resource, name, errors.New(`admission webhook "validate.example.com" denied the request`))
+			case name == "redhat-operators":
+				return k8sErrors.NewForbidden(resource, name, errors.New("no RBAC"))
+			}
+			return c.Apply(ctx, obj, opts...)
+		},
+	}).Build())
+	ctx := context.TODO()
+	failures := metrics.DefaultCatalogEnsureFailures.WithLabelValues("redhat-operators", string(ErrorClassPermanent))
+	previousFailures := counterValue(t, failures)
+
+	conflictRetry := wait.Backoff{Steps: 3}
+	backoff := wait.Backoff{Duration: time.Minute, Factor: 2, Steps: 4, Cap: 3 * time.Minute}
+	d := New(definitions, config, WithBackoff(conflictRetry, backoff))
+	result := d.EnsureAll(ctx, client)
+
+	// Conflicts are retried right away, up to the steps of the conflict
+	// backoff
+	assert.Equal(t, Result{Action: ActionCreate, Attempts: 3}, result["community-operators"])
+	r := result["conflicted-operators"]
+	assert.Equal(t, ErrorClassConflict, r.ErrorClass)
+	assert.Equal(t, 3, r.Attempts)
+	assert.Equal(t, time.Minute, r.RequeueAfter)
+
+	// Sources that keep failing are requeued with an exponential backoff of
+	// their own, up to its cap
+	assert.Equal(t, 2*time.Minute, d.EnsureSource(ctx, client, "conflicted-operators").RequeueAfter)
+	assert.Equal(t, 3*time.Minute, d.EnsureSource(ctx, client, "conflicted-operators").RequeueAfter)
+	assert.Equal(t, 3*time.Minute, d.EnsureSource(ctx, client, "conflicted-operators").RequeueAfter)
+
+	// Other retriable errors are requeued rather than retried, and the
+	// backoff is reset once the source is ensured
+	r = result["certified-operators"]
+	assert.Equal(t, ErrorClassTransient, r.ErrorClass)
+	assert.True(t, k8sErrors.IsServiceUnavailable(r.Err))
+	assert.Equal(t, 1, r.Attempts)
+	assert.Equal(t, time.Minute, r.RequeueAfter)
+	r = d.EnsureSource(ctx, client, "certified-operators")
+	assert.Equal(t, Result{Action: ActionCreate, Attempts: 1}, r)
+	backoffLock.Lock()
+	assert.NotContains(t, backoffs, "certified-operators")
+	assert.Contains(t, backoffs, "conflicted-operators")
+	backoffLock.Unlock()
+
+	// Denials and other permanent errors are neither retried nor requeued
+	r = result["denied-operators"]
+	assert.Equal(t, ErrorClassDenied, r.ErrorClass)
+	assert.Equal(t, 1, r.Attempts)
+	assert.Zero(t, r.RequeueAfter)
+	r = result["redhat-operators"]
+	assert.Equal(t, ErrorClassPermanent, r.ErrorClass)
+	assert.True(t, k8sErrors.IsForbidden(r.Err))
+	assert.Equal(t, 1, r.Attempts)
+	assert.Zero(t, r.RequeueAfter)
+	assert.Equal(t, previousFailures+1, counterValue(t, failures))
+}
+
+func TestWaitForDeletion(t *testing.T) {
+	resetState(t)
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"redhat-operators": catsrcs[0]}
+	config := map[string]bool{"redhat-operators": false}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build())
+	ctx := context.TODO()
+	key := wrapper.ObjectKey{Name: "redhat-operators", Namespace: "openshift-marketplace"}
+
+	require.NoError(t, New(definitions, config).Ensure(ctx, client, "redhat-operators"))
+	cluster := &olmv1alpha1.CatalogSource{}
+	require.NoError(t, client.Get(ctx, key, cluster))
+	cluster.Finalizers = []string{"example.com/cleanup"}
+	cluster.Spec.Image = "registry.io/redhat:changed"
+	require.NoError(t, client.Update(ctx, cluster))
+	require.NoError(t, client.Delete(ctx, cluster))
+
+	// Nothing is applied while the CatalogSource is terminating
+	recorder := events.NewFakeRecorder(10)
+	result := New(definitions, config, WithEventRecorder(recorder, nil)).EnsureSource(ctx, client, "redhat-operators")
+	assert.Equal(t, Result{Action: ActionWaitForDeletion, Attempts: 1, RequeueAfter: TerminationRequeueInterval}, result)
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:changed", cluster.Spec.Image)
+	assert.Empty(t, stuckSources)
+
+	// It is reported once it has been terminating for too long
+	defer func(timeout time.Duration) { TerminationTimeout = timeout }(TerminationTimeout)
+	TerminationTimeout = 0
+	for i := 0; i < 2; i++ {
+		result = New(definitions, config, WithEventRecorder(recorder, nil)).EnsureSource(ctx, client, "redhat-operators")
+		assert.Equal(t, ActionWaitForDeletion, result.Action)
+	}
+	assert.Contains(t, stuckSources["redhat-operators"], "with the finalizers example.com/cleanup")
+	require.Len(t, recorder.Events, 1, "the CatalogSource is reported as stuck once")
+	assert.Equal(t, "Warning DefaultCatalogSourceTerminating The CatalogSource has been terminating for more than 0s with the finalizers example.com/cleanup, it is recreated once it is gone", <-recorder.Events)
+
+	// It is recreated once it is gone
+	cluster.Finalizers = nil
+	require.NoError(t, client.Update(ctx, cluster))
+	result = New(definitions, config).EnsureSource(ctx, client, "redhat-operators")
+	assert.Equal(t, Result{Action: ActionCreate, Attempts: 1}, result)
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/redhat:v4.23", cluster.Spec.Image)
+	assert.Empty(t, stuckSources)
+}
+
+func TestContention(t *testing.T) {
+	resetState(t)
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "contested-operators", "registry.io/contested:v4.23")))
+	require.NoError(t, err)
+	definitions := map[string]olmv1alpha1.CatalogSource{"contested-operators": catsrcs[0]}
+	config := map[string]bool{"contested-operators": false}
+	defer func(threshold int, backoff time.Duration) {
+		FlappingThreshold, FlappingBackoff = threshold, backoff
+	}(FlappingThreshold, FlappingBackoff)
+	FlappingThreshold, FlappingBackoff = 2, time.Minute
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	client := wrapper.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithReturnManagedFields().Build())
+	ctx := context.TODO()
+	key := wrapper.ObjectKey{Name: "contested-operators", Namespace: "openshift-marketplace"}
+	recorder := events.NewFakeRecorder(10)
+	d := New(definitions, config, WithEventRecorder(recorder, nil))
+	require.NoError(t, d.Ensure(ctx, client, "contested-operators"))
+	<-recorder.Events
+
+	cluster := &olmv1alpha1.CatalogSource{}
+	change := func() {
+		require.NoError(t, client.Get(ctx, key, cluster))
+		cluster.Spec.Image = "registry.io/contested:gitops"
+		cluster.ManagedFields = nil
+		require.NoError(t, client.Update(ctx, cluster, crclient.FieldOwner("gitops")))
+	}
+
+	// The CatalogSource is restored until the threshold is crossed
+	for i := 0; i < 2; i++ {
+		change()
+		assert.Equal(t, ActionRestore, d.EnsureSource(ctx, client, "contested-operators").Action)
+		<-recorder.Events
+		// The restore itself is in sync and does not settle the contention
+		assert.Equal(t, ActionNone, d.EnsureSource(ctx, client, "contested-operators").Action)
+	}
+	assert.Equal(t, "Warning DefaultCatalogSourceContested The CatalogSource was restored 2 times within 10m0s as it keeps being changed by gitops, restoring it is backed off for 1m0s", <-recorder.Events)
+
+	// Restoring it is then backed off and the competing field manager named
+	change()
+	result := d.EnsureSource(ctx, client, "contested-operators")
+	assert.Equal(t, ActionContested, result.Action)
+	assert.Equal(t, []string{"gitops"}, result.ContestedBy)
+	assert.InDelta(t, time.Minute, result.RequeueAfter, float64(5*time.Second))
+	require.NoError(t, client.Get(ctx, key, cluster))
+	assert.Equal(t, "registry.io/contested:gitops", cluster.Spec.Image)
+
+	// The backoff doubles when the CatalogSource is still contested once it
+	// expires
+	contentions["contested-operators"].until = time.Now()
+	assert.Equal(t, ActionRestore, d.EnsureSource(ctx, client, "contested-operators").Action)
+	<-recorder.Events
+	assert.Contains(t, <-recorder.Events, "restoring it is backed off for 2m0s")
+
+	// It is forgotten once it is left alone
+	contentions["contested-operators"].until = time.Now()
+	contentions["contested-operators"].restores = nil
+	assert.Equal(t, ActionNone, d.EnsureSource(ctx, client, "contested-operators").Action)
+	assert.NotContains(t, contentions, "contested-operators")
+}
+
+func TestDiagnosePods(t *testing.T) {
+	resetState(t)
+	catsrcs, _, err := decodeDefinitions(strings.NewReader(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")))
+	require.NoError(t, err)
+	// The CatalogSource is diagnosed as it is on the cluster
+	catsrc := &catsrcs[0]
+	catsrc.ResourceVersion = "1"
+	memoryTarget := resource.MustParse("30Mi")
+	catsrc.Spec.GrpcPodConfig = &olmv1alpha1.GrpcPodConfig{MemoryTarget: &memoryTarget}
+
+	waiting := func(reason, message string) corev1.PodStatus {
+		return corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "registry-server",
+			Image: "registry.io/redhat:v4.23",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message}},
+		}}}
+	}
+	crashLoop := func(lastReason string) corev1.PodStatus {
+		status := waiting("CrashLoopBackOff", "back-off restarting failed container")
+		status.ContainerStatuses[0].RestartCount = 7
+		status.ContainerStatuses[0].LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: lastReason}
+		return status
+	}
+	unschedulable := corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{{
+			Type:    corev1.PodScheduled,
+			Status:  corev1.ConditionFalse,
+			Reason:  corev1.PodReasonUnschedulable,
+			Message: "0/3 nodes are available",
+		}},
+	}
+
+	tests := []struct {
+		name         string
+		nodeSelector map[string]string
+		status       corev1.PodStatus
+		failure      PodFailure
+	}{
+		{"auth", nil, waiting("ImagePullBackOff", "unauthorized: authentication required"), PodFailureImagePullAuth},
+		{"manifest", nil, waiting("ErrImagePull", "manifest unknown: manifest unknown"), PodFailureManifestUnknown},
+		{"unreachable", nil, waiting("ErrImagePull", "dial tcp: lookup registry.io: no such host"), PodFailureRegistryUnreachable},
+		{"manifest not found", nil, waiting("ErrImagePull", "registry.io/redhat:v4.23: not found: manifest v4.23"), PodFailureManifestUnknown},
+		{"unknown pull error", nil, waiting("ErrImagePull", "something else"), ""},
+		{"other not found error", nil, waiting("ErrImagePull", "container runtime not found"), ""},
+		{"pull back-off", nil, waiting("ImagePullBackOff", `Back-off pulling image "registry.io/redhat:v4.23"`), ""},
+		{"unschedulable", map[string]string{"node-role.kubernetes.io/master": ""}, unschedulable, PodFailureUnschedulable},
+		{"unschedulable without master nodeSelector", nil, unschedulable, ""},
+		{"oom", nil, crashLoop("OOMKilled"), PodFailureOOMKilled},
+		{"crash", nil, crashLoop("Error"), PodFailureCrashLoop},
+		{"running", nil, corev1.PodStatus{Phase: corev1.PodRunning}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators-abcde"},
+				Spec: corev1.PodSpec{
+					NodeSelector: tt.nodeSelector,
+					Containers:   []corev1.Container{{Name: "registry-server", Image: "registry.io/redhat:v4.23"}},
+				},
+				Status: tt.status,
+			}
+			diagnosis := diagnosePod(pod, catsrc)
+			if tt.failure == "" {
+				assert.Nil(t, diagnosis)
+				return
+			}
+			require.NotNil(t, diagnosis)
+			assert.Equal(t, tt.failure, diagnosis.Failure)
+			assert.Equal(t, "redhat-operators-abcde", diagnosis.Pod)
+			assert.Equal(t, "container registry-server with the image registry.io/redhat:v4.23", diagnosis.Summary())
+		})
+	}
+
+	// A new diagnosis is reported once, and cleared with the metric
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators-abcde", Namespace: catsrc.Namespace, Labels: map[string]string{CatalogSourceLabelKey: catsrc.Name}},
+		Status:     crashLoop("OOMKilled"),
+	}
+	other := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-abcde", Namespace: catsrc.Namespace, Labels: map[string]string{CatalogSourceLabelKey: "other"}},
+		Status:     crashLoop("Error"),
+	}
+	podClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod, other).Build()
+	client := wrapper.NewClient(podClient)
+	recorder := events.NewFakeRecorder(10)
+	gauge := func() float64 {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.DefaultCatalogPodFailures.WithLabelValues("redhat-operators", string(PodFailureOOMKilled)).Write(m))
+		return m.GetGauge().GetValue()
+	}
+	for i := 0; i < 2; i++ {
+		diagnosis, err := DiagnosePods(context.TODO(), client, recorder, nil, catsrc)
+		require.NoError(t, err)
+		require.NotNil(t, diagnosis)
+		assert.Equal(t, "pod redhat-operators-abcde was OOMKilled, container registry-server needs more memory than the memoryTarget of 30Mi", diagnosis.String())
+	}
+	crash := diagnosePod(other, catsrc)
+	require.NotNil(t, crash)
+	assert.Equal(t, "pod other-abcde keeps crashing, container registry-server restarted 7 times", crash.String())
+	assert.Equal(t, "container registry-server with the image registry.io/redhat:v4.23", crash.Summary())
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning DefaultCatalogSourcePodFailing The registry is failing with OOMKilled: pod redhat-operators-abcde was OOMKilled, container registry-server needs more memory than the memoryTarget of 30Mi", <-recorder.Events)
+	assert.Equal(t, float64(1), gauge())
+	ClearDiagnosis("redhat-operators")
+	assert.Equal(t, float64(0), gauge())
+
+	// A pull failure is kept while the kubelet backs off from pulling the
+	// image, as it no longer says why the pull failed
+	manifestGauge := func() float64 {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.DefaultCatalogPodFailures.WithLabelValues("redhat-operators", string(PodFailureManifestUnknown)).Write(m))
+		return m.GetGauge().GetValue()
+	}
+	for _, status := range []corev1.PodStatus{
+		waiting("ErrImagePull", "manifest unknown: manifest unknown"),
+		waiting("ImagePullBackOff", `Back-off pulling image "registry.io/redhat:v4.23"`),
+		waiting("ErrImagePull", "manifest unknown: manifest unknown"),
+		waiting("ImagePullBackOff", `Back-off pulling image "registry.io/redhat:v4.23"`),
+	} {
+		pod.Status = status
+		require.NoError(t, podClient.Status().Update(context.TODO(), pod))
+		diagnosis, err := DiagnosePods(context.TODO(), client, recorder, nil, catsrc)
+		require.NoError(t, err)
+		require.NotNil(t, diagnosis)
+		assert.Equal(t, PodFailureManifestUnknown, diagnosis.Failure)
+		assert.Equal(t, float64(1), manifestGauge())
+	}
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning DefaultCatalogSourcePodFailing The registry is failing with ManifestUnknown")
+
+	// and cleared once the image is pulled
+	pod.Status = corev1.PodStatus{Phase: corev1.PodRunning}
+	require.NoError(t, podClient.Status().Update(context.TODO(), pod))
+	diagnosis, err := DiagnosePods(context.TODO(), client, recorder, nil, catsrc)
+	require.NoError(t, err)
+	assert.Nil(t, diagnosis)
+	assert.Equal(t, float64(0), manifestGauge())
+
+	// A back-off without a previous pull failure is not diagnosed
+	pod.Status = waiting("ImagePullBackOff", `Back-off pulling image "registry.io/redhat:v4.23"`)
+	require.NoError(t, podClient.Status().Update(context.TODO(), pod))
+	diagnosis, err = DiagnosePods(context.TODO(), client, recorder, nil, catsrc)
+	require.NoError(t, err)
+	assert.Nil(t, diagnosis)
+}
@@ -0,0 +1,133 @@
+package defaults
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// definitionSet is a set of CatalogSource definitions loaded from a single
+// source, such as the defaults directory.
+type definitionSet struct {
+	catsrcDefinitions map[string]olmv1alpha1.CatalogSource
+	// companions maps the name of each definition to the companion objects
+	// loaded along with it
+	companions map[string][]unstructured.Unstructured
+	// origins maps the name of each definition to the file it was loaded from
+	origins map[string]string
+	// notApplicable maps the name of each definition that was excluded by its
+	// cluster version constraints to the reason it was excluded
+	notApplicable map[string]string
+	loadErrors    []LoadError
+}
+
+func newDefinitionSet() definitionSet {
+	return definitionSet{
+		catsrcDefinitions: make(map[string]olmv1alpha1.CatalogSource),
+		companions:        make(map[string][]unstructured.Unstructured),
+		origins:           make(map[string]string),
+		notApplicable:     make(map[string]string),
+	}
+}
+
+// add validates the CatalogSources and companion objects loaded from origin
+// and adds them to the set. Either all of them are added or, if any of them is
+// invalid, none are. CatalogSources that do not apply to the cluster version
+// are recorded as not applicable instead. As they are never reconciled, they
+// may share their name with other definitions.
+func (s *definitionSet) add(origin string, catsrcs []olmv1alpha1.CatalogSource, companions []unstructured.Unstructured, templateContext TemplateContext) error {
+	inOrigin := make(map[string]bool)
+	notApplicable := make(map[string]string)
+	for i := range catsrcs {
+		catsrc := &catsrcs[i]
+		if err := setWatchNamespace(catsrc, templateContext.WatchNamespace); err != nil {
+			return err
+		}
+		if err := validateCatsrcDefinition(catsrc); err != nil {
+			return err
+		}
+
+		reason, err := checkClusterVersion(catsrc, templateContext.releaseVersion)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			notApplicable[catsrc.Name] = reason
+			continue
+		}
+
+		if previous, present := s.origins[catsrc.Name]; present {
+			return fmt.Errorf("default CatalogSource %s is defined in both %s and %s", catsrc.Name, previous, origin)
+		}
+		if inOrigin[catsrc.Name] {
+			return fmt.Errorf("default CatalogSource %s is defined more than once in the same file", catsrc.Name)
+		}
+		inOrigin[catsrc.Name] = true
+
+		// Rewrite image tags from their templates for default CatalogSources
+		if err := overrideImageTag(catsrc, templateContext); err != nil {
+			return fmt.Errorf("unable to update image tags for default CatalogSource %s: %w", catsrc.Name, err)
+		}
+	}
+
+	groups, err := groupCompanions(catsrcs, companions)
+	if err != nil {
+		return err
+	}
+
+	for _, catsrc := range catsrcs {
+		if !inOrigin[catsrc.Name] {
+			continue
+		}
+		s.catsrcDefinitions[catsrc.Name] = catsrc
+		s.origins[catsrc.Name] = origin
+		delete(s.notApplicable, catsrc.Name)
+		if group, present := groups[catsrc.Name]; present {
+			s.companions[catsrc.Name] = group
+		}
+	}
+	for name, reason := range notApplicable {
+		if _, present := s.catsrcDefinitions[name]; !present {
+			s.notApplicable[name] = reason
+		}
+	}
+	return nil
+}
+
+// setWatchNamespace places a CatalogSource that omits its namespace in the
+// namespace the operator watches. A CatalogSource in any other namespace is
+// rejected. Nothing is enforced if the watch namespace is unknown.
+func setWatchNamespace(catsrc *olmv1alpha1.CatalogSource, watchNamespace string) error {
+	if watchNamespace == "" {
+		return nil
+	}
+	if catsrc.Namespace == "" {
+		catsrc.Namespace = watchNamespace
+	}
+	if catsrc.Namespace != watchNamespace {
+		return fmt.Errorf("CatalogSource %s must be in the %s namespace watched by the operator, not %s", catsrc.Name, watchNamespace, catsrc.Namespace)
+	}
+	return nil
+}
+
+// names returns the names of the definitions in the set in sorted order
+func (s *definitionSet) names() []string {
+	names := make([]string, 0, len(s.catsrcDefinitions))
+	for name := range s.catsrcDefinitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// equal returns true if both sets hold the same definitions and load errors
+func (s *definitionSet) equal(other definitionSet) bool {
+	return reflect.DeepEqual(s.catsrcDefinitions, other.catsrcDefinitions) &&
+		reflect.DeepEqual(s.companions, other.companions) &&
+		reflect.DeepEqual(s.origins, other.origins) &&
+		reflect.DeepEqual(s.notApplicable, other.notApplicable) &&
+		loadErrorsEqual(s.loadErrors, other.loadErrors)
+}
@@ -0,0 +1,256 @@
+package defaults
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CatalogSourceLabelKey is the label OLM sets on the pods of a CatalogSource
+// to the name of the CatalogSource
+const CatalogSourceLabelKey string = "olm.catalogSource"
+
+// PodFailure is the category of the failure of the pod of a default
+// CatalogSource. The categories are part of the API of the operator, alerts
+// may match on them.
+type PodFailure string
+
+const (
+	// PodFailureImagePullAuth is reported when the catalog image cannot be
+	// pulled because the registry rejected the credentials
+	PodFailureImagePullAuth PodFailure = "ImagePullAuth"
+	// PodFailureManifestUnknown is reported when the catalog image does not
+	// exist in the registry
+	PodFailureManifestUnknown PodFailure = "ManifestUnknown"
+	// PodFailureRegistryUnreachable is reported when the registry of the
+	// catalog image cannot be reached
+	PodFailureRegistryUnreachable PodFailure = "RegistryUnreachable"
+	// PodFailureUnschedulable is reported when the pod cannot be scheduled
+	// because of its master or control plane nodeSelector
+	PodFailureUnschedulable PodFailure = "Unschedulable"
+	// PodFailureOOMKilled is reported when the registry was killed for
+	// running out of memory
+	PodFailureOOMKilled PodFailure = "OOMKilled"
+	// PodFailureCrashLoop is reported when the registry keeps crashing for
+	// any other reason
+	PodFailureCrashLoop PodFailure = "CrashLoop"
+)
+
+// PodFailingReason is the reason of the events recorded when the failure of
+// the pod of a default CatalogSource is diagnosed. The action of the event is
+// the category of the failure.
+const PodFailingReason string = "DefaultCatalogSourcePodFailing"
+
+// masterNodeSelectorKeys are the nodeSelector keys that restrict a pod to the
+// master nodes
+var masterNodeSelectorKeys = []string{"node-role.kubernetes.io/master", "node-role.kubernetes.io/control-plane"}
+
+// Diagnosis is the failure found for the pods of a default CatalogSource
+type Diagnosis struct {
+	// Pod is the name of the failing pod
+	Pod     string
+	Failure PodFailure
+	// Container and Image are the failing container of the pod and its
+	// image, or the first container of a pod that cannot be scheduled
+	Container string
+	Image     string
+	// Detail explains the failure, including the messages and restart
+	// counts reported by the kubelet
+	Detail string
+}
+
+// String returns the failing pod and why it is failing
+func (d Diagnosis) String() string {
+	return fmt.Sprintf("pod %s %s", d.Pod, d.Detail)
+}
+
+// Summary returns the failing container and its image. Unlike String, it does
+// not change while the failure lasts, so it is what the OperatorHub status
+// reports.
+func (d Diagnosis) Summary() string {
+	if d.Container == "" {
+		return "the pod of the registry is failing"
+	}
+	return fmt.Sprintf("container %s with the image %s", d.Container, d.Image)
+}
+
+var (
+	// diagnoses holds the last diagnosis reported for each default
+	// CatalogSource, so that each one is only recorded once
+	diagnoses     = make(map[string]Diagnosis)
+	diagnosesLock sync.Mutex
+)
+
+// DiagnosePods finds the pods of the given default CatalogSource through the
+// CatalogSourceLabelKey label and returns the failure of the first one that
+// is failing in a known way, or nil if none is. A new diagnosis is logged,
+// recorded as a PodFailingReason event, on the OperatorHub too if hub is not
+// nil, and reported by the DefaultCatalogPodFailures metric.
+func DiagnosePods(ctx context.Context, client wrapper.Client, recorder events.EventRecorder, hub runtime.Object, catsrc *olmv1alpha1.CatalogSource) (*Diagnosis, error) {
+	pods := &corev1.PodList{}
+	if err := client.List(ctx, pods, crclient.InNamespace(catsrc.Namespace), crclient.MatchingLabels{CatalogSourceLabelKey: catsrc.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list the pods of CatalogSource %s: %w", catsrc.Name, err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	var diagnosis *Diagnosis
+	for i := range pods.Items {
+		if diagnosis = diagnosePod(&pods.Items[i], catsrc); diagnosis != nil {
+			break
+		}
+	}
+
+	diagnosesLock.Lock()
+	defer diagnosesLock.Unlock()
+	if diagnosis == nil {
+		// The kubelet only says why the image cannot be pulled when pulling
+		// it, not while backing off, so the failure found then is kept
+		if previous, present := diagnoses[catsrc.Name]; present && isPullFailure(previous.Failure) && backingOffPull(pods.Items) {
+			return &previous, nil
+		}
+		clearDiagnosis(catsrc.Name)
+		return nil, nil
+	}
+	if previous, present := diagnoses[catsrc.Name]; present && previous.Failure == diagnosis.Failure {
+		return diagnosis, nil
+	}
+	diagnoses[catsrc.Name] = *diagnosis
+	metrics.DefaultCatalogPodFailures.DeletePartialMatch(prometheus.Labels{"source": catsrc.Name})
+	metrics.DefaultCatalogPodFailures.WithLabelValues(catsrc.Name, string(diagnosis.Failure)).Set(1)
+	logrus.Warnf("[defaults] CatalogSource %s is failing with %s - %s", catsrc.Name, diagnosis.Failure, diagnosis)
+	(&eventRecorder{recorder: recorder, hub: hub}).warning(catsrc, PodFailingReason, string(diagnosis.Failure), "The registry is failing with %s: %s", diagnosis.Failure, diagnosis)
+	return diagnosis, nil
+}
+
+// ClearDiagnosis forgets the diagnosis of the given default CatalogSource once
+// it is healthy again
+func ClearDiagnosis(sourceName string) {
+	diagnosesLock.Lock()
+	defer diagnosesLock.Unlock()
+	clearDiagnosis(sourceName)
+}
+
+// clearDiagnosis is ClearDiagnosis for callers that hold diagnosesLock
+func clearDiagnosis(sourceName string) {
+	if _, present := diagnoses[sourceName]; !present {
+		return
+	}
+	delete(diagnoses, sourceName)
+	metrics.DefaultCatalogPodFailures.DeletePartialMatch(prometheus.Labels{"source": sourceName})
+}
+
+// isPullFailure returns true if the given failure is found in the message of
+// an image pull error
+func isPullFailure(failure PodFailure) bool {
+	switch failure {
+	case PodFailureImagePullAuth, PodFailureManifestUnknown, PodFailureRegistryUnreachable:
+		return true
+	}
+	return false
+}
+
+// backingOffPull returns true if a container of one of the given pods is
+// backing off from pulling its image
+func backingOffPull(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		for _, status := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+			if waiting := status.State.Waiting; waiting != nil && waiting.Reason == "ImagePullBackOff" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// diagnosePod returns the failure of the given pod of a CatalogSource, or nil
+// if it is not failing in a known way
+func diagnosePod(pod *corev1.Pod, catsrc *olmv1alpha1.CatalogSource) *Diagnosis {
+	if pod.Status.Phase == corev1.PodPending {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type != corev1.PodScheduled || condition.Status != corev1.ConditionFalse || condition.Reason != corev1.PodReasonUnschedulable {
+				continue
+			}
+			for _, key := range masterNodeSelectorKeys {
+				if _, present := pod.Spec.NodeSelector[key]; present {
+					diagnosis := &Diagnosis{Pod: pod.Name, Failure: PodFailureUnschedulable, Detail: fmt.Sprintf("cannot be scheduled with the nodeSelector %s: %s", key, condition.Message)}
+					if len(pod.Spec.Containers) > 0 {
+						diagnosis.Container, diagnosis.Image = pod.Spec.Containers[0].Name, pod.Spec.Containers[0].Image
+					}
+					return diagnosis
+				}
+			}
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if diagnosis := diagnoseContainer(status, catsrc); diagnosis != nil {
+			diagnosis.Pod, diagnosis.Container, diagnosis.Image = pod.Name, status.Name, status.Image
+			return diagnosis
+		}
+	}
+	return nil
+}
+
+// diagnoseContainer returns the failure of the given container of a pod of
+// a CatalogSource, or nil if it is not failing in a known way
+func diagnoseContainer(status corev1.ContainerStatus, catsrc *olmv1alpha1.CatalogSource) *Diagnosis {
+	if waiting := status.State.Waiting; waiting != nil {
+		switch waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff":
+			if failure, ok := classifyPullError(waiting.Message); ok {
+				return &Diagnosis{Failure: failure, Detail: fmt.Sprintf("cannot pull the image %s: %s", status.Image, waiting.Message)}
+			}
+		case "CrashLoopBackOff":
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+				return oomKilled(status, catsrc)
+			}
+			return &Diagnosis{Failure: PodFailureCrashLoop, Detail: fmt.Sprintf("keeps crashing, container %s restarted %d times", status.Name, status.RestartCount)}
+		}
+	}
+	if terminated := status.State.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+		return oomKilled(status, catsrc)
+	}
+	return nil
+}
+
+// oomKilled returns the diagnosis of a container that ran out of memory
+func oomKilled(status corev1.ContainerStatus, catsrc *olmv1alpha1.CatalogSource) *Diagnosis {
+	if config := catsrc.Spec.GrpcPodConfig; config != nil && config.MemoryTarget != nil {
+		return &Diagnosis{Failure: PodFailureOOMKilled, Detail: fmt.Sprintf("was OOMKilled, container %s needs more memory than the memoryTarget of %s", status.Name, config.MemoryTarget)}
+	}
+	return &Diagnosis{Failure: PodFailureOOMKilled, Detail: fmt.Sprintf("was OOMKilled, container %s ran out of memory without a memoryTarget set", status.Name)}
+}
+
+// classifyPullError returns the failure category of the given image pull
+// error message
+func classifyPullError(message string) (PodFailure, bool) {
+	message = strings.ToLower(message)
+	for _, c := range []struct {
+		failure   PodFailure
+		fragments []string
+	}{
+		{PodFailureImagePullAuth, []string{"unauthorized", "authentication required", "403 forbidden", "access denied", "requested access to the resource is denied"}},
+		{PodFailureManifestUnknown, []string{"manifest unknown", "name unknown", "not found: manifest"}},
+		{PodFailureRegistryUnreachable, []string{"no such host", "dial tcp", "i/o timeout", "connection refused", "tls handshake timeout", "network is unreachable", "deadline exceeded"}},
+	} {
+		for _, fragment := range c.fragments {
+			if strings.Contains(message, fragment) {
+				return c.failure, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,159 @@
+package defaults
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// unknownWriter is reported for drifted fields that no other field manager
+// owns, for example fields that were removed from the CatalogSource
+const unknownWriter = "unknown"
+
+// fieldDrift is a field of a default CatalogSource whose value on the cluster
+// differs from its definition
+type fieldDrift struct {
+	// path is the path of the field, such as spec.image
+	path []string
+	// writers are the field managers other than the operator that own the
+	// field on the cluster
+	writers []string
+}
+
+// Path returns the path of the field joined with dots
+func (f fieldDrift) Path() string {
+	return strings.Join(f.path, ".")
+}
+
+// String returns the path of the field and who changed it
+func (f fieldDrift) String() string {
+	return fmt.Sprintf("%s (by %s)", f.Path(), strings.Join(f.writers, ", "))
+}
+
+// computeDrift returns the fields of the desired CatalogSource that differ on
+// the cluster and were changed by someone other than the operator. Fields
+// only the operator owns differ because the definition itself has changed,
+// so they are not reported.
+func computeDrift(desired *unstructured.Unstructured, cluster *olmv1alpha1.CatalogSource) ([]fieldDrift, error) {
+	current, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths [][]string
+	for _, field := range []string{"metadata", "spec"} {
+		paths = append(paths, diffPaths(desired.Object[field], current[field], []string{field})...)
+	}
+	for _, key := range []string{OriginalImageAnnotationKey, OverriddenFieldsAnnotationKey} {
+		if _, stale := cluster.Annotations[key]; stale {
+			if _, wanted := desired.GetAnnotations()[key]; !wanted {
+				paths = append(paths, []string{"metadata", "annotations", key})
+			}
+		}
+	}
+
+	managedFields, err := parseManagedFields(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []fieldDrift
+	for _, path := range paths {
+		var writers []string
+		ownedByOperator := false
+		for manager, fields := range managedFields {
+			if !ownsPath(fields, path) {
+				continue
+			}
+			if manager == FieldManager {
+				ownedByOperator = true
+				continue
+			}
+			writers = append(writers, manager)
+		}
+		if ownedByOperator && len(writers) == 0 {
+			continue
+		}
+		if len(writers) == 0 {
+			writers = []string{unknownWriter}
+		}
+		sort.Strings(writers)
+		drift = append(drift, fieldDrift{path: path, writers: writers})
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Path() < drift[j].Path() })
+	return drift, nil
+}
+
+// diffPaths returns the paths of the fields set in desired that are missing
+// or set to a different value in actual. Lists are compared as a whole.
+func diffPaths(desired, actual interface{}, path []string) [][]string {
+	d, ok := desired.(map[string]interface{})
+	if !ok {
+		if isSubset(desired, actual) {
+			return nil
+		}
+		return [][]string{path}
+	}
+
+	a, ok := actual.(map[string]interface{})
+	if !ok {
+		return [][]string{path}
+	}
+	var paths [][]string
+	for key, value := range d {
+		fieldPath := append(append([]string{}, path...), key)
+		paths = append(paths, diffPaths(value, a[key], fieldPath)...)
+	}
+	return paths
+}
+
+// parseManagedFields returns the fields owned by each field manager of the
+// given object, in the FieldsV1 format
+func parseManagedFields(cluster *olmv1alpha1.CatalogSource) (map[string]map[string]interface{}, error) {
+	managedFields := make(map[string]map[string]interface{})
+	for _, entry := range cluster.ManagedFields {
+		if entry.FieldsV1 == nil || entry.Subresource != "" {
+			continue
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			return nil, fmt.Errorf("invalid managed fields of %s: %w", entry.Manager, err)
+		}
+		if existing, present := managedFields[entry.Manager]; present {
+			fields = mergeFieldSets(existing, fields)
+		}
+		managedFields[entry.Manager] = fields
+	}
+	return managedFields, nil
+}
+
+// mergeFieldSets returns the union of the given FieldsV1 sets
+func mergeFieldSets(a, b map[string]interface{}) map[string]interface{} {
+	for key, value := range b {
+		existing, ok := a[key].(map[string]interface{})
+		if nested, isMap := value.(map[string]interface{}); ok && isMap {
+			a[key] = mergeFieldSets(existing, nested)
+			continue
+		}
+		a[key] = value
+	}
+	return a
+}
+
+// ownsPath returns true if the given FieldsV1 set contains the field at path
+// or any of the fields below it
+func ownsPath(fields map[string]interface{}, path []string) bool {
+	for _, name := range path {
+		next, ok := fields["f:"+name].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		fields = next
+	}
+	return true
+}
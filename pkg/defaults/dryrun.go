@@ -0,0 +1,65 @@
+package defaults
+
+import (
+	"fmt"
+	"strconv"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// DryRunAnnotationKey is the annotation on the cluster OperatorHub that
+// enables the dry-run mode when set to true
+const DryRunAnnotationKey string = "operatorframework.io/default-catalogsources-dry-run"
+
+// DryRunReason is the reason of the events recorded for the actions that
+// would have been taken on the default CatalogSources in dry-run mode. The
+// action of the event is the action that would have been taken.
+const DryRunReason string = "DefaultCatalogSourceDryRun"
+
+// DryRun is set to true to compute and report the actions on the default
+// CatalogSources without taking them
+var DryRun bool
+
+// WithDryRun enables the dry-run mode in addition to DryRun
+func WithDryRun(dryRun bool) Option {
+	return func(d *defaults) {
+		d.dryRun = dryRun
+	}
+}
+
+// ParseDryRun parses the value of the DryRunAnnotationKey annotation. The
+// dry-run mode is disabled if the annotation is not set. A value that cannot
+// be parsed enables it, along with the error, so that a mistyped annotation
+// does not let changes through.
+func ParseDryRun(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return true, fmt.Errorf("invalid %s annotation %q, it must be true or false", DryRunAnnotationKey, value)
+	}
+	return dryRun, nil
+}
+
+// reportDryRun logs and records the action that would have been taken on the
+// given CatalogSource. The event is only recorded again once the action
+// changes.
+func reportDryRun(recorder *eventRecorder, catsrc *olmv1alpha1.CatalogSource, action Action, note string, args ...interface{}) {
+	note = fmt.Sprintf(note, args...)
+	logrus.Infof("[defaults] Dry run: CatalogSource %s - would %s", catsrc.Name, note)
+	recorder.normalOnChange(catsrc, DryRunReason, string(action), "Dry run: would %s", note)
+}
+
+// setDryRunAction reports the action that would have been taken on the given
+// source in the metrics. The actions of the source are cleared when the
+// dry-run mode is disabled.
+func setDryRunAction(sourceName string, action Action, dryRun bool) {
+	metrics.DefaultCatalogDryRunActions.DeletePartialMatch(prometheus.Labels{"source": sourceName})
+	if dryRun && action != ActionNone {
+		metrics.DefaultCatalogDryRunActions.WithLabelValues(sourceName, string(action)).Set(1)
+	}
+}
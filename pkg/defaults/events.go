@@ -0,0 +1,172 @@
+package defaults
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+)
+
+// EventRecorderName is the name the events about the default CatalogSources
+// are reported under
+const EventRecorderName string = "marketplace-operator"
+
+// The reasons of the events recorded about the default CatalogSources. They
+// are part of the API of the operator, alerts may match on them.
+const (
+	// CreatedReason is recorded when a default CatalogSource is created
+	CreatedReason string = "DefaultCatalogSourceCreated"
+	// UpdatedReason is recorded when a default CatalogSource is updated
+	// because its definition changed
+	UpdatedReason string = "DefaultCatalogSourceUpdated"
+	// RestoredReason is recorded when fields of a default CatalogSource that
+	// were changed on the cluster are restored to their definition
+	RestoredReason string = "DefaultCatalogSourceRestored"
+	// DeletedReason is recorded when a disabled default CatalogSource is
+	// deleted
+	DeletedReason string = "DefaultCatalogSourceDeleted"
+	// SkippedReason is recorded when a disabled default CatalogSource is not
+	// deleted because it is not annotated as managed by the operator
+	SkippedReason string = "DefaultCatalogSourceSkipped"
+	// AdoptedReason is recorded when a CatalogSource that was not created by
+	// the operator is taken over
+	AdoptedReason string = "DefaultCatalogSourceAdopted"
+	// NotAdoptedReason is recorded when a CatalogSource that was not created
+	// by the operator is not taken over because of the adoption policy
+	NotAdoptedReason string = "DefaultCatalogSourceNotAdopted"
+	// ContestedReason is recorded when restoring a default CatalogSource is
+	// backed off because another field manager keeps changing it
+	ContestedReason string = "DefaultCatalogSourceContested"
+	// FieldConflictReason is recorded when fields of a default CatalogSource
+	// or of its companion objects are owned by other field managers and are
+	// not taken over because of the conflict policy
+	FieldConflictReason string = "DefaultCatalogSourceFieldConflict"
+	// TerminatingReason is recorded when a default CatalogSource has been
+	// terminating for longer than TerminationTimeout
+	TerminatingReason string = "DefaultCatalogSourceTerminating"
+	// UnmanagedReason is recorded when a default CatalogSource is left as it
+	// is because it is annotated as unmanaged
+	UnmanagedReason string = "DefaultCatalogSourceUnmanaged"
+	// FailedReason is recorded when a default CatalogSource could not be
+	// reconciled
+	FailedReason string = "DefaultCatalogSourceFailed"
+)
+
+// WithEventRecorder sets the recorder that events about the default
+// CatalogSources are recorded with. If hub is not nil, every event is also
+// recorded on it, with the CatalogSource as the related object.
+func WithEventRecorder(recorder events.EventRecorder, hub runtime.Object) Option {
+	return func(d *defaults) {
+		d.recorder = &eventRecorder{recorder: recorder, hub: hub}
+	}
+}
+
+// eventRecorder records the events about the default CatalogSources. A nil
+// eventRecorder records nothing.
+type eventRecorder struct {
+	recorder events.EventRecorder
+	// hub is the OperatorHub the events are also recorded on
+	hub runtime.Object
+}
+
+var (
+	// reportedStates holds the event last recorded about each default
+	// CatalogSource that is left in a state the operator does not act on,
+	// such as an unmanaged CatalogSource. The event is only recorded again
+	// once the state changes.
+	reportedStates     = make(map[string]string)
+	reportedStatesLock sync.Mutex
+)
+
+// record records an event regarding the given CatalogSource, and the
+// OperatorHub if it is set. The event is only recorded on the OperatorHub if
+// the CatalogSource is not on the cluster, such as one that would be created
+// in dry-run mode.
+func (e *eventRecorder) record(catsrc *olmv1alpha1.CatalogSource, eventType, reason, action, note string, args ...interface{}) {
+	if e == nil || e.recorder == nil {
+		return
+	}
+	setReportedState(catsrc.Name, "")
+	e.emit(catsrc, eventType, reason, action, note, args...)
+}
+
+// recordOnChange records an event like record, unless it is the event last
+// recorded about the state of the CatalogSource
+func (e *eventRecorder) recordOnChange(catsrc *olmv1alpha1.CatalogSource, eventType, reason, action, note string, args ...interface{}) {
+	if e == nil || e.recorder == nil {
+		return
+	}
+	if setReportedState(catsrc.Name, strings.Join([]string{eventType, reason, action, fmt.Sprintf(note, args...)}, "/")) {
+		e.emit(catsrc, eventType, reason, action, note, args...)
+	}
+}
+
+// emit records the event on the CatalogSource and the OperatorHub
+func (e *eventRecorder) emit(catsrc *olmv1alpha1.CatalogSource, eventType, reason, action, note string, args ...interface{}) {
+	if catsrc.ResourceVersion != "" {
+		e.recorder.Eventf(catsrc, nil, eventType, reason, action, note, args...)
+	}
+	if e.hub != nil {
+		var related runtime.Object
+		if catsrc.ResourceVersion != "" {
+			related = catsrc
+		}
+		e.recorder.Eventf(e.hub, related, eventType, reason, action, "CatalogSource %s: %s", catsrc.Name, fmt.Sprintf(note, args...))
+	}
+}
+
+// normal records an event of type Normal
+func (e *eventRecorder) normal(catsrc *olmv1alpha1.CatalogSource, reason, action, note string, args ...interface{}) {
+	e.record(catsrc, corev1.EventTypeNormal, reason, action, note, args...)
+}
+
+// warning records an event of type Warning
+func (e *eventRecorder) warning(catsrc *olmv1alpha1.CatalogSource, reason, action, note string, args ...interface{}) {
+	e.record(catsrc, corev1.EventTypeWarning, reason, action, note, args...)
+}
+
+// normalOnChange records an event of type Normal about the state of the
+// CatalogSource if it changed
+func (e *eventRecorder) normalOnChange(catsrc *olmv1alpha1.CatalogSource, reason, action, note string, args ...interface{}) {
+	e.recordOnChange(catsrc, corev1.EventTypeNormal, reason, action, note, args...)
+}
+
+// warningOnChange records an event of type Warning about the state of the
+// CatalogSource if it changed
+func (e *eventRecorder) warningOnChange(catsrc *olmv1alpha1.CatalogSource, reason, action, note string, args ...interface{}) {
+	e.recordOnChange(catsrc, corev1.EventTypeWarning, reason, action, note, args...)
+}
+
+// setReportedState records the event last recorded about the state of the
+// given CatalogSource, or forgets it if state is empty. It returns true if
+// the state changed.
+func setReportedState(sourceName, state string) bool {
+	reportedStatesLock.Lock()
+	defer reportedStatesLock.Unlock()
+
+	if reportedStates[sourceName] == state {
+		return false
+	}
+	if state == "" {
+		delete(reportedStates, sourceName)
+	} else {
+		reportedStates[sourceName] = state
+	}
+	return true
+}
+
+// clusterCatsrc returns the given CatalogSource as it is on the cluster for
+// events to be recorded on, or def if it cannot be read
+func clusterCatsrc(ctx context.Context, client wrapper.Client, def *olmv1alpha1.CatalogSource) *olmv1alpha1.CatalogSource {
+	cluster := &olmv1alpha1.CatalogSource{}
+	if err := client.Get(ctx, wrapper.ObjectKey{Name: def.Name, Namespace: def.Namespace}, cluster); err != nil {
+		return def
+	}
+	return cluster
+}
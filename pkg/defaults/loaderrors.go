@@ -0,0 +1,88 @@
+package defaults
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/operator-framework/operator-marketplace/pkg/status"
+	"github.com/sirupsen/logrus"
+)
+
+// InvalidDefinitionsReason is the ClusterOperator Degraded reason reported
+// while some of the files in the defaults directory fail to load.
+const InvalidDefinitionsReason = "InvalidDefaultCatalogSources"
+
+// globalLoadErrors holds the files that failed to load when the globals were
+// last merged. It is guarded by globalsLock.
+var globalLoadErrors []LoadError
+
+// LoadError records a file in the defaults directory, or a key in a defaults
+// ConfigMap, that could not be loaded
+type LoadError struct {
+	// File is either the path of the file relative to the defaults directory
+	// or configmaps/<name>/<key> for a ConfigMap key
+	File string
+	Err  error
+}
+
+func (e LoadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+// GetLoadErrors returns the files and ConfigMaps that failed to load into
+// the current global definitions.
+func GetLoadErrors() []LoadError {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
+	return globalLoadErrors
+}
+
+// checkLoadErrors returns an error if files failed to load and none of the
+// definitions in the set could be loaded.
+func checkLoadErrors(set definitionSet) error {
+	if len(set.loadErrors) == 0 || len(set.catsrcDefinitions) > 0 {
+		return nil
+	}
+	return fmt.Errorf("no valid default CatalogSource definitions could be loaded: %s", joinLoadErrors(set.loadErrors))
+}
+
+// setLoadErrors records the given load errors and reports them through the
+// ClusterOperator and metrics. The caller is expected to hold globalsLock.
+func setLoadErrors(loadErrors []LoadError) {
+	globalLoadErrors = loadErrors
+
+	metrics.DefaultDefinitionLoadErrors.Reset()
+	if len(loadErrors) == 0 {
+		status.ClearDegraded(InvalidDefinitionsReason)
+		return
+	}
+
+	for _, loadError := range loadErrors {
+		logrus.Warnf("[defaults] Skipping invalid default CatalogSource definitions in %s - %v", loadError.File, loadError.Err)
+		metrics.DefaultDefinitionLoadErrors.WithLabelValues(loadError.File).Set(1)
+	}
+	status.SetDegraded(InvalidDefinitionsReason, fmt.Sprintf("Failed to load default CatalogSource definitions: %s", joinLoadErrors(loadErrors)))
+}
+
+// loadErrorsEqual returns true if both lists report the same errors for the
+// same files.
+func loadErrorsEqual(a, b []LoadError) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Error() != b[i].Error() {
+			return false
+		}
+	}
+	return true
+}
+
+func joinLoadErrors(loadErrors []LoadError) string {
+	messages := make([]string, 0, len(loadErrors))
+	for _, loadError := range loadErrors {
+		messages = append(messages, loadError.Error())
+	}
+	return strings.Join(messages, "; ")
+}
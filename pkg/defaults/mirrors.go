@@ -0,0 +1,183 @@
+package defaults
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/docker/reference"
+	configv1 "github.com/openshift/api/config/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	mktconfig "github.com/operator-framework/operator-marketplace/pkg/apis/config/v1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// OriginalImageAnnotationKey is the annotation that records the image of a
+// default CatalogSource before it was rewritten to a mirror.
+const OriginalImageAnnotationKey string = "operatorframework.io/original-image"
+
+// MirrorImages configures whether the images of the default CatalogSources
+// are rewritten to the mirrors configured through the cluster's
+// ImageDigestMirrorSets and ImageTagMirrorSets.
+var MirrorImages bool
+
+// imageMirrors is a mirror configuration entry common to
+// ImageDigestMirrorSets and ImageTagMirrorSets.
+type imageMirrors struct {
+	source  string
+	mirrors []configv1.ImageMirror
+}
+
+// mirrorImage rewrites the image of the given CatalogSource to its mirror
+// and records the original image in the OriginalImageAnnotationKey
+// annotation. Digest based images are looked up in the ImageDigestMirrorSets,
+// all others in the ImageTagMirrorSets. The image is left untouched if no
+// mirror matches it.
+func mirrorImage(ctx context.Context, client wrapper.Client, catsrc *olmv1alpha1.CatalogSource) error {
+	if len(catsrc.Spec.Image) == 0 || !mktconfig.IsAPIAvailable() {
+		return nil
+	}
+
+	ref, err := reference.ParseNormalizedNamed(catsrc.Spec.Image)
+	if err != nil {
+		return fmt.Errorf("invalid image %s for CatalogSource %s: %w", catsrc.Spec.Image, catsrc.Name, err)
+	}
+
+	var mirrors []imageMirrors
+	if _, ok := ref.(reference.Canonical); ok {
+		mirrors, err = getDigestMirrors(ctx, client)
+	} else {
+		mirrors, err = getTagMirrors(ctx, client)
+	}
+	if err != nil {
+		return err
+	}
+
+	mirrored, err := resolveMirror(ref, mirrors)
+	if err != nil {
+		return fmt.Errorf("unable to mirror image %s for CatalogSource %s: %w", catsrc.Spec.Image, catsrc.Name, err)
+	}
+	if mirrored == "" {
+		return nil
+	}
+
+	if catsrc.Annotations == nil {
+		catsrc.Annotations = make(map[string]string)
+	}
+	catsrc.Annotations[OriginalImageAnnotationKey] = catsrc.Spec.Image
+	catsrc.Spec.Image = mirrored
+	return nil
+}
+
+// getDigestMirrors returns the mirrors of all the ImageDigestMirrorSets on
+// the cluster.
+func getDigestMirrors(ctx context.Context, client wrapper.Client) ([]imageMirrors, error) {
+	list := &configv1.ImageDigestMirrorSetList{}
+	if err := client.List(ctx, list); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	var mirrors []imageMirrors
+	for _, set := range list.Items {
+		for _, m := range set.Spec.ImageDigestMirrors {
+			mirrors = append(mirrors, imageMirrors{source: m.Source, mirrors: m.Mirrors})
+		}
+	}
+	return mirrors, nil
+}
+
+// getTagMirrors returns the mirrors of all the ImageTagMirrorSets on the
+// cluster.
+func getTagMirrors(ctx context.Context, client wrapper.Client) ([]imageMirrors, error) {
+	list := &configv1.ImageTagMirrorSetList{}
+	if err := client.List(ctx, list); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	var mirrors []imageMirrors
+	for _, set := range list.Items {
+		for _, m := range set.Spec.ImageTagMirrors {
+			mirrors = append(mirrors, imageMirrors{source: m.Source, mirrors: m.Mirrors})
+		}
+	}
+	return mirrors, nil
+}
+
+// resolveMirror returns the given image rewritten to the first mirror of the
+// most specific source that matches it, or an empty string if there is none.
+// As in the node's registries configuration, a source matches the repository
+// itself or any repository below it, and a wildcard source of the form
+// *.host matches every registry under host. A wildcard source is less
+// specific than any other source.
+func resolveMirror(ref reference.Named, mirrors []imageMirrors) (string, error) {
+	name := ref.Name()
+	domain := reference.Domain(ref)
+
+	var (
+		best         *imageMirrors
+		bestPrefix   string
+		bestWildcard bool
+	)
+	for i := range mirrors {
+		m := &mirrors[i]
+		if len(m.mirrors) == 0 {
+			continue
+		}
+
+		var prefix string
+		wildcard := strings.HasPrefix(m.source, "*.")
+		switch {
+		case wildcard:
+			if !strings.HasSuffix(domain, m.source[1:]) {
+				continue
+			}
+			prefix = domain
+		case name == m.source || strings.HasPrefix(name, m.source+"/"):
+			prefix = m.source
+		default:
+			continue
+		}
+
+		// The first of equally specific sources wins
+		if best == nil || moreSpecific(m.source, wildcard, best.source, bestWildcard) {
+			best, bestPrefix, bestWildcard = m, prefix, wildcard
+		}
+	}
+	if best == nil {
+		return "", nil
+	}
+
+	mirrored, err := reference.ParseNormalizedNamed(string(best.mirrors[0]) + strings.TrimPrefix(name, bestPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	switch r := ref.(type) {
+	case reference.Canonical:
+		mirrored, err = reference.WithDigest(mirrored, r.Digest())
+	case reference.NamedTagged:
+		mirrored, err = reference.WithTag(mirrored, r.Tag())
+	}
+	if err != nil {
+		return "", err
+	}
+	return mirrored.String(), nil
+}
+
+// moreSpecific returns true if source a is more specific than source b
+func moreSpecific(a string, aWildcard bool, b string, bWildcard bool) bool {
+	if aWildcard != bWildcard {
+		return bWildcard
+	}
+	return len(a) > len(b)
+}
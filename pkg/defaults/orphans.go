@@ -0,0 +1,139 @@
+package defaults
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	wrapper "github.com/operator-framework/operator-marketplace/pkg/client"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OrphanPolicy is what is done with the CatalogSources that are annotated as
+// managed by the operator but no longer have a default definition
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyDelete deletes the orphaned CatalogSources
+	OrphanPolicyDelete OrphanPolicy = "delete"
+	// OrphanPolicyKeep keeps the orphaned CatalogSources and only logs them
+	OrphanPolicyKeep OrphanPolicy = "keep"
+	// OrphanPolicyWarn keeps the orphaned CatalogSources and reports them in
+	// events and the OperatorHub status
+	OrphanPolicyWarn OrphanPolicy = "warn"
+)
+
+// OrphanedReason is the reason of the events recorded about orphaned
+// CatalogSources. The action of the event is Delete if the CatalogSource was
+// deleted.
+const OrphanedReason string = "DefaultCatalogSourceOrphaned"
+
+// OrphanedDefaultsPolicy is the policy applied to the orphaned CatalogSources
+var OrphanedDefaultsPolicy = OrphanPolicyWarn
+
+// String implements flag.Value
+func (p *OrphanPolicy) String() string {
+	return string(*p)
+}
+
+// Set implements flag.Value
+func (p *OrphanPolicy) Set(value string) error {
+	switch OrphanPolicy(value) {
+	case OrphanPolicyDelete, OrphanPolicyKeep, OrphanPolicyWarn:
+		*p = OrphanPolicy(value)
+		return nil
+	}
+	return fmt.Errorf("unsupported policy %q, it must be one of %s, %s or %s", value, OrphanPolicyDelete, OrphanPolicyKeep, OrphanPolicyWarn)
+}
+
+// Orphan is a CatalogSource that is annotated as managed by the operator but
+// no longer has a default definition
+type Orphan struct {
+	Name string
+	// Action is ActionDelete if the CatalogSource was deleted, or would have
+	// been in dry-run mode
+	Action Action
+	// DryRun is true if the action was computed without being taken
+	DryRun bool
+	// Reason explains why the CatalogSource was kept
+	Reason string
+	Err    error
+}
+
+// SweepOrphans finds the CatalogSources in the watch namespace that are
+// annotated as managed by the operator but have no default definition, and
+// applies OrphanedDefaultsPolicy to them. Orphans are never deleted until
+// the definitions have been loaded from all their sources, or while some of
+// them fail to load, as their definition may be among them.
+func (d *defaults) SweepOrphans(ctx context.Context, client wrapper.Client) ([]Orphan, error) {
+	globalsLock.RLock()
+	namespace := globalTemplateContext.WatchNamespace
+	loaded := dirDefinitionsLoaded && (configMapDefinitionsLoaded || !configMapDefinitionsExpected)
+	globalsLock.RUnlock()
+
+	catsrcs := &olmv1alpha1.CatalogSourceList{}
+	if err := client.List(ctx, catsrcs, crclient.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list CatalogSources: %w", err)
+	}
+
+	dryRun := d.dryRun || DryRun
+	policy := OrphanedDefaultsPolicy
+	loadErrors := GetLoadErrors()
+
+	var orphans []Orphan
+	metrics.DefaultCatalogOrphans.Reset()
+	for i := range catsrcs.Items {
+		catsrc := &catsrcs.Items[i]
+		if _, present := d.catsrcDefinitions[catsrc.Name]; present {
+			continue
+		}
+		if catsrc.Annotations[defaultCatsrcAnnotationKey] != defaultCatsrcAnnotationValue || !catsrc.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		orphan := Orphan{Name: catsrc.Name, DryRun: dryRun}
+		switch {
+		case isUnmanaged(catsrc):
+			orphan.Reason = fmt.Sprintf("it is annotated with %s=true", UnmanagedAnnotationKey)
+		case policy != OrphanPolicyDelete:
+			orphan.Reason = fmt.Sprintf("the orphaned CatalogSources policy is %s", policy)
+		case !loaded:
+			orphan.Reason = "the default definitions have not all been loaded yet"
+		case len(loadErrors) > 0:
+			orphan.Reason = "some of the default definitions failed to load"
+		case dryRun:
+			orphan.Action = ActionDelete
+			reportDryRun(d.recorder, catsrc, ActionDelete, "delete the CatalogSource as it no longer has a default definition")
+		default:
+			orphan.Action = ActionDelete
+			if err := client.Delete(ctx, catsrc); err != nil && !k8sErrors.IsNotFound(err) {
+				orphan.Action = ActionNone
+				orphan.Err = fmt.Errorf("failed to delete the orphaned CatalogSource: %w", err)
+			}
+		}
+
+		switch {
+		case orphan.Err != nil:
+			logrus.Errorf("[defaults] Error deleting orphaned CatalogSource %s - %v", catsrc.Name, orphan.Err)
+			d.recorder.warning(catsrc, FailedReason, "Delete", "%v", orphan.Err)
+		case orphan.Action == ActionDelete && !dryRun:
+			logrus.Infof("[defaults] Deleting orphaned CatalogSource %s as it no longer has a default definition", catsrc.Name)
+			d.recorder.normal(catsrc, OrphanedReason, "Delete", "Deleted the CatalogSource as it no longer has a default definition")
+			continue
+		case policy == OrphanPolicyKeep:
+			logrus.Infof("[defaults] Keeping orphaned CatalogSource %s as %s", catsrc.Name, orphan.Reason)
+		case orphan.Action == ActionNone:
+			logrus.Warnf("[defaults] CatalogSource %s no longer has a default definition and is kept as %s", catsrc.Name, orphan.Reason)
+			d.recorder.warning(catsrc, OrphanedReason, "Keep", "The CatalogSource no longer has a default definition and is kept as %s", orphan.Reason)
+		}
+		metrics.DefaultCatalogOrphans.WithLabelValues(catsrc.Name).Set(1)
+		orphans = append(orphans, orphan)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Name < orphans[j].Name })
+	return orphans, nil
+}
@@ -0,0 +1,175 @@
+package defaults
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// OverridesAnnotationKey is the annotation on the cluster OperatorHub that
+// holds the overrides of the default CatalogSources. Its value is a JSON
+// object that maps the name of a default CatalogSource to its Override.
+const OverridesAnnotationKey string = "operatorframework.io/default-catalogsource-overrides"
+
+// OverriddenFieldsAnnotationKey is the annotation that lists the overridden
+// fields on a default CatalogSource. A stale annotation marks the
+// CatalogSource as out of sync, so that the fields are restored to the
+// definition once their override is removed.
+const OverriddenFieldsAnnotationKey string = "operatorframework.io/overridden-fields"
+
+// Override holds the fields of a default CatalogSource that can be changed by
+// the cluster administrator. Each field that is set replaces the
+// corresponding field of the definition as a whole.
+type Override struct {
+	Priority     *int                `json:"priority,omitempty"`
+	PollInterval string              `json:"pollInterval,omitempty"`
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	MemoryTarget *resource.Quantity  `json:"memoryTarget,omitempty"`
+}
+
+// ParseOverrides parses the value of the OverridesAnnotationKey annotation.
+// Unknown fields are rejected. The overrides themselves are only validated
+// when they are applied.
+func ParseOverrides(value string) (map[string]Override, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	overrides := make(map[string]Override)
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", OverridesAnnotationKey, err)
+	}
+	return overrides, nil
+}
+
+// Fields returns the names of the fields set by the override in sorted order
+func (o Override) Fields() []string {
+	var fields []string
+	if o.Priority != nil {
+		fields = append(fields, "priority")
+	}
+	if o.PollInterval != "" {
+		fields = append(fields, "pollInterval")
+	}
+	if o.NodeSelector != nil {
+		fields = append(fields, "nodeSelector")
+	}
+	if o.Tolerations != nil {
+		fields = append(fields, "tolerations")
+	}
+	if o.MemoryTarget != nil {
+		fields = append(fields, "memoryTarget")
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// validate returns an error for every invalid field of the override
+func (o Override) validate() error {
+	var errs []error
+	if o.PollInterval != "" {
+		if interval, err := time.ParseDuration(o.PollInterval); err != nil {
+			errs = append(errs, fmt.Errorf("pollInterval: %w", err))
+		} else if interval <= 0 {
+			errs = append(errs, fmt.Errorf("pollInterval: %s must be positive", o.PollInterval))
+		}
+	}
+	for key := range o.NodeSelector {
+		if key == "" {
+			errs = append(errs, errors.New("nodeSelector: keys must not be empty"))
+		}
+	}
+	for i, toleration := range o.Tolerations {
+		if err := validateToleration(toleration); err != nil {
+			errs = append(errs, fmt.Errorf("tolerations[%d]: %w", i, err))
+		}
+	}
+	if o.MemoryTarget != nil && o.MemoryTarget.Sign() < 0 {
+		errs = append(errs, fmt.Errorf("memoryTarget: %s must not be negative", o.MemoryTarget))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateToleration performs the basic validation of a toleration done by
+// the API server for pods
+func validateToleration(toleration corev1.Toleration) error {
+	switch toleration.Operator {
+	case corev1.TolerationOpEqual, "":
+		if toleration.Key == "" {
+			return errors.New("operator must be Exists when key is empty")
+		}
+	case corev1.TolerationOpExists:
+		if toleration.Value != "" {
+			return errors.New("value must be empty when operator is Exists")
+		}
+	default:
+		return fmt.Errorf("unsupported operator %q", toleration.Operator)
+	}
+
+	switch toleration.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return fmt.Errorf("unsupported effect %q", toleration.Effect)
+	}
+	if toleration.TolerationSeconds != nil && toleration.Effect != corev1.TaintEffectNoExecute {
+		return errors.New("tolerationSeconds requires the NoExecute effect")
+	}
+	return nil
+}
+
+// apply merges the override onto the given CatalogSource definition
+func (o Override) apply(catsrc *olmv1alpha1.CatalogSource) {
+	if o.Priority != nil {
+		catsrc.Spec.Priority = *o.Priority
+	}
+	if o.PollInterval != "" {
+		catsrc.Spec.UpdateStrategy = &olmv1alpha1.UpdateStrategy{
+			RegistryPoll: &olmv1alpha1.RegistryPoll{RawInterval: o.PollInterval},
+		}
+	}
+	if o.NodeSelector == nil && o.Tolerations == nil && o.MemoryTarget == nil {
+		return
+	}
+
+	if catsrc.Spec.GrpcPodConfig == nil {
+		catsrc.Spec.GrpcPodConfig = &olmv1alpha1.GrpcPodConfig{}
+	}
+	if o.NodeSelector != nil {
+		catsrc.Spec.GrpcPodConfig.NodeSelector = o.NodeSelector
+	}
+	if o.Tolerations != nil {
+		catsrc.Spec.GrpcPodConfig.Tolerations = o.Tolerations
+	}
+	if o.MemoryTarget != nil {
+		memoryTarget := o.MemoryTarget.DeepCopy()
+		catsrc.Spec.GrpcPodConfig.MemoryTarget = &memoryTarget
+	}
+}
+
+// applyOverride validates the override and merges it onto the given
+// CatalogSource definition. An invalid override is not applied at all.
+func applyOverride(catsrc *olmv1alpha1.CatalogSource, override Override) error {
+	if err := override.validate(); err != nil {
+		return fmt.Errorf("invalid overrides for CatalogSource %s, the default definition is applied without them: %w", catsrc.Name, err)
+	}
+	override.apply(catsrc)
+	if fields := override.Fields(); len(fields) > 0 {
+		if catsrc.Annotations == nil {
+			catsrc.Annotations = make(map[string]string)
+		}
+		catsrc.Annotations[OverriddenFieldsAnnotationKey] = strings.Join(fields, ",")
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package defaults
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// ErrorClass is the class of the error that prevented a default CatalogSource
+// from being ensured. It decides whether the CatalogSource is retried.
+type ErrorClass string
+
+const (
+	// ErrorClassNone is reported when the CatalogSource was ensured
+	ErrorClassNone ErrorClass = ""
+	// ErrorClassConflict is reported when the CatalogSource was changed
+	// concurrently
+	ErrorClassConflict ErrorClass = "Conflict"
+	// ErrorClassTimeout is reported when a request to the API server timed
+	// out
+	ErrorClassTimeout ErrorClass = "Timeout"
+	// ErrorClassDenied is reported when an admission webhook denied a request.
+	// It is not retried, the webhook is bound to deny it again until the
+	// webhook or the definition changes.
+	ErrorClassDenied ErrorClass = "Denied"
+	// ErrorClassTransient is reported when the API server is temporarily
+	// unable to serve a request, or the request failed for an unknown reason
+	ErrorClassTransient ErrorClass = "Transient"
+	// ErrorClassPermanent is reported when a request was rejected and is
+	// bound to be rejected again, or the CatalogSource cannot be ensured
+	// without changes to its definition or overrides
+	ErrorClassPermanent ErrorClass = "Permanent"
+)
+
+var (
+	// EnsureWorkers is the number of default CatalogSources EnsureAll
+	// processes concurrently
+	EnsureWorkers = 4
+
+	// EnsureConflictRetry is the backoff with which ensuring a default
+	// CatalogSource is retried with retry.RetryOnConflict when it fails with
+	// a conflict. Conflicts are retried right away, as the CatalogSource is
+	// read again on every attempt.
+	EnsureConflictRetry = retry.DefaultRetry
+
+	// EnsureBackoff is the backoff after which a default CatalogSource that
+	// failed with a retriable error is ensured again. It is kept for each
+	// CatalogSource, the delay doubles with every consecutive failure up to
+	// the cap and is reset once the CatalogSource is ensured. Nothing waits
+	// for it, it is returned as the RequeueAfter of the Result.
+	EnsureBackoff = wait.Backoff{
+		Duration: 10 * time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    6,
+		Cap:      5 * time.Minute,
+	}
+
+	backoffLock sync.Mutex
+	// backoffs holds the backoff of the default CatalogSources that last
+	// failed with a retriable error, keyed by name
+	backoffs = make(map[string]*wait.Backoff)
+)
+
+// WithBackoff sets the backoff with which conflicts are retried and the
+// backoff after which a default CatalogSource that failed with a retriable
+// error is ensured again, in place of EnsureConflictRetry and EnsureBackoff
+func WithBackoff(conflictRetry, backoff wait.Backoff) Option {
+	return func(d *defaults) {
+		d.conflictRetry, d.backoff = conflictRetry, backoff
+	}
+}
+
+// classifyError returns the class of the given error
+func classifyError(err error) ErrorClass {
+	var conflict *fieldConflictError
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.As(err, &conflict), len(applyConflicts(err)) > 0:
+		// Fields owned by other field managers stay theirs however many
+		// times the apply is retried
+		return ErrorClassPermanent
+	case k8sErrors.IsConflict(err):
+		return ErrorClassConflict
+	case k8sErrors.IsTimeout(err), k8sErrors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case strings.Contains(err.Error(), "admission webhook") && strings.Contains(err.Error(), "denied the request"):
+		return ErrorClassDenied
+	case k8sErrors.IsTooManyRequests(err), k8sErrors.IsServiceUnavailable(err), k8sErrors.IsInternalError(err), k8sErrors.IsUnexpectedServerError(err):
+		return ErrorClassTransient
+	case errors.Is(err, context.Canceled), k8sErrors.IsInvalid(err), k8sErrors.IsBadRequest(err), k8sErrors.IsForbidden(err),
+		k8sErrors.IsUnauthorized(err), k8sErrors.IsMethodNotSupported(err), k8sErrors.IsNotAcceptable(err),
+		k8sErrors.IsUnsupportedMediaType(err), k8sErrors.IsRequestEntityTooLargeError(err):
+		return ErrorClassPermanent
+	}
+	return ErrorClassTransient
+}
+
+// retriable returns true if an error of this class may go away on its own
+func (c ErrorClass) retriable() bool {
+	switch c {
+	case ErrorClassConflict, ErrorClassTimeout, ErrorClassTransient:
+		return true
+	}
+	return false
+}
+
+// withRetries calls fn with retry.RetryOnConflict, until it succeeds, it
+// fails with an error other than a conflict or the backoff is exhausted. It
+// returns the last error of fn and the number of times it was called.
+func withRetries(backoff wait.Backoff, fn func() error) (int, error) {
+	attempts := 0
+	err := retry.RetryOnConflict(backoff, func() error {
+		attempts++
+		return fn()
+	})
+	return attempts, err
+}
+
+// nextRetry returns how long after failing with a retriable error the given
+// default CatalogSource is ensured again, and steps its backoff. The backoff
+// starts from the given one on the first failure.
+func nextRetry(name string, backoff wait.Backoff) time.Duration {
+	backoffLock.Lock()
+	defer backoffLock.Unlock()
+	b, present := backoffs[name]
+	if !present {
+		b = &backoff
+		backoffs[name] = b
+	}
+	return b.Step()
+}
+
+// resetRetry resets the backoff of the given default CatalogSource once it
+// no longer fails with a retriable error
+func resetRetry(name string) {
+	backoffLock.Lock()
+	defer backoffLock.Unlock()
+	delete(backoffs, name)
+}
+
+// forEachConcurrently calls fn for each of the given names, running at most
+// workers calls at a time. It returns once all the calls are done.
+func forEachConcurrently(names []string, workers int, fn func(name string)) {
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(names); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				fn(name)
+			}
+		}()
+	}
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+}
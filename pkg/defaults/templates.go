@@ -0,0 +1,75 @@
+package defaults
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	semver "github.com/blang/semver/v4"
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+// TemplateContext is the data that the default definitions, and the image tag
+// templates in them, are rendered with.
+type TemplateContext struct {
+	// WatchNamespace is the namespace the operator watches. Every default
+	// CatalogSource is placed in it.
+	WatchNamespace string
+	// ReleaseVersion is the release version of the operator. It is empty if
+	// the release version is unknown, in which case Major, Minor and Patch
+	// are zero.
+	ReleaseVersion string
+	Major          uint64
+	Minor          uint64
+	Patch          uint64
+	// ControlPlaneTopology and InfrastructureTopology are the topologies
+	// reported by the cluster Infrastructure, such as HighlyAvailable,
+	// SingleReplica or External.
+	ControlPlaneTopology   string
+	InfrastructureTopology string
+	// Platform is the infrastructure platform of the cluster, such as AWS or
+	// None.
+	Platform string
+
+	releaseVersion *semver.Version
+}
+
+// NewTemplateContext returns the TemplateContext for an operator watching
+// watchNamespace at the given release version. Both releaseVersion and infra
+// may be nil if they are unknown, for example outside of OpenShift.
+func NewTemplateContext(watchNamespace string, releaseVersion *semver.Version, infra *configv1.Infrastructure) TemplateContext {
+	tc := TemplateContext{
+		WatchNamespace: watchNamespace,
+		releaseVersion: releaseVersion,
+	}
+	if releaseVersion != nil {
+		tc.ReleaseVersion = releaseVersion.String()
+		tc.Major = releaseVersion.Major
+		tc.Minor = releaseVersion.Minor
+		tc.Patch = releaseVersion.Patch
+	}
+	if infra != nil {
+		tc.ControlPlaneTopology = string(infra.Status.ControlPlaneTopology)
+		tc.InfrastructureTopology = string(infra.Status.InfrastructureTopology)
+		tc.Platform = string(infra.Status.Platform)
+		if infra.Status.PlatformStatus != nil {
+			tc.Platform = string(infra.Status.PlatformStatus.Type)
+		}
+	}
+	return tc
+}
+
+// renderDefinitions renders the contents of a defaults file, or of a defaults
+// ConfigMap key, as a template. Referring to a field that does not exist in
+// the TemplateContext is an error.
+func renderDefinitions(name string, content []byte, tc TemplateContext) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	rendered := &bytes.Buffer{}
+	if err := tmpl.Execute(rendered, tc); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return rendered.Bytes(), nil
+}
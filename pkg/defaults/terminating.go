@@ -0,0 +1,93 @@
+package defaults
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/operator-framework/operator-marketplace/pkg/status"
+	"github.com/sirupsen/logrus"
+)
+
+// StuckTerminatingReason is the ClusterOperator Degraded reason reported
+// while some default CatalogSources have been terminating for longer than
+// TerminationTimeout.
+const StuckTerminatingReason string = "DefaultCatalogSourcesStuckTerminating"
+
+var (
+	// TerminationTimeout is how long a default CatalogSource may be
+	// terminating before the operator reports it as stuck
+	TerminationTimeout = 10 * time.Minute
+
+	// TerminationRequeueInterval is how often a terminating default
+	// CatalogSource is checked until it is gone and can be recreated
+	TerminationRequeueInterval = 10 * time.Second
+
+	// stuckSources maps the names of the default CatalogSources that have
+	// been terminating for longer than TerminationTimeout to why they are
+	// stuck
+	stuckSources = make(map[string]string)
+	stuckLock    sync.Mutex
+)
+
+// waitForDeletion is called for a default CatalogSource that is enabled but
+// is being deleted. Nothing is applied to it, as that would not stop the
+// deletion. The CatalogSource is recreated once it is gone, which the caller
+// is expected to check again after TerminationRequeueInterval.
+func waitForDeletion(recorder *eventRecorder, cluster *olmv1alpha1.CatalogSource) Action {
+	terminating := time.Since(cluster.DeletionTimestamp.Time)
+	if terminating < TerminationTimeout {
+		logrus.Infof("[defaults] CatalogSource %s is being deleted, waiting for it to be gone before recreating it", cluster.Name)
+		setStuckTerminating(cluster.Name, "")
+		return ActionWaitForDeletion
+	}
+
+	finalizers := "no finalizers"
+	if len(cluster.Finalizers) > 0 {
+		finalizers = "the finalizers " + strings.Join(cluster.Finalizers, ", ")
+	}
+	reason := fmt.Sprintf("%s has been terminating since %s with %s", cluster.Name, cluster.DeletionTimestamp.UTC().Format(time.RFC3339), finalizers)
+	if setStuckTerminating(cluster.Name, reason) {
+		logrus.Warnf("[defaults] CatalogSource %s has been terminating for more than %s with %s, it is recreated once it is gone", cluster.Name, TerminationTimeout, finalizers)
+		recorder.warning(cluster, TerminatingReason, "Recreate", "The CatalogSource has been terminating for more than %s with %s, it is recreated once it is gone", TerminationTimeout, finalizers)
+	}
+	return ActionWaitForDeletion
+}
+
+// setStuckTerminating records why the given default CatalogSource is stuck
+// terminating, or that it is not if reason is empty, and reports the stuck
+// CatalogSources through the ClusterOperator. It returns true if the
+// CatalogSource was not stuck before.
+func setStuckTerminating(sourceName, reason string) bool {
+	stuckLock.Lock()
+	defer stuckLock.Unlock()
+
+	previous, wasStuck := stuckSources[sourceName]
+	if previous == reason {
+		return false
+	}
+	if reason != "" {
+		stuckSources[sourceName] = reason
+	} else {
+		delete(stuckSources, sourceName)
+	}
+
+	if len(stuckSources) == 0 {
+		status.ClearDegraded(StuckTerminatingReason)
+		return false
+	}
+	names := make([]string, 0, len(stuckSources))
+	for name := range stuckSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, stuckSources[name])
+	}
+	status.SetDegraded(StuckTerminatingReason, fmt.Sprintf("Default CatalogSources have been terminating for more than %s and cannot be recreated: %s", TerminationTimeout, strings.Join(reasons, "; ")))
+	return !wasStuck
+}
@@ -0,0 +1,61 @@
+package defaults
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/operator-framework/operator-marketplace/pkg/status"
+)
+
+// UnmanagedAnnotationKey is the annotation that stops the operator from
+// enforcing the definition of a default CatalogSource when it is set to
+// true on the CatalogSource. The CatalogSource is neither restored nor
+// deleted, so that it can be fixed in place.
+const UnmanagedAnnotationKey string = "operatorframework.io/default-catalogsource-unmanaged"
+
+// UnmanagedSourcesReason is the ClusterOperator Upgradeable reason reported
+// while some default CatalogSources are unmanaged.
+const UnmanagedSourcesReason string = "UnmanagedDefaultCatalogSources"
+
+var (
+	// unmanagedSources holds the names of the default CatalogSources that
+	// are currently unmanaged
+	unmanagedSources = make(map[string]bool)
+	unmanagedLock    sync.Mutex
+)
+
+// isUnmanaged returns true if the given CatalogSource on the cluster is
+// annotated as unmanaged
+func isUnmanaged(cluster *olmv1alpha1.CatalogSource) bool {
+	return strings.EqualFold(cluster.Annotations[UnmanagedAnnotationKey], "true")
+}
+
+// setUnmanaged records whether the given default CatalogSource is unmanaged
+// and reports the unmanaged CatalogSources through the ClusterOperator.
+func setUnmanaged(sourceName string, unmanaged bool) {
+	unmanagedLock.Lock()
+	defer unmanagedLock.Unlock()
+
+	if unmanagedSources[sourceName] == unmanaged {
+		return
+	}
+	if unmanaged {
+		unmanagedSources[sourceName] = true
+	} else {
+		delete(unmanagedSources, sourceName)
+	}
+
+	if len(unmanagedSources) == 0 {
+		status.ClearNotUpgradeable(UnmanagedSourcesReason)
+		return
+	}
+	names := make([]string, 0, len(unmanagedSources))
+	for name := range unmanagedSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	status.SetNotUpgradeable(UnmanagedSourcesReason, fmt.Sprintf("The default CatalogSources %s are annotated with %s=true and are not managed by the operator. Remove the annotation before upgrading.", strings.Join(names, ", "), UnmanagedAnnotationKey))
+}
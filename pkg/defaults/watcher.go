@@ -0,0 +1,183 @@
+package defaults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/operator-framework/operator-marketplace/pkg/filemonitor"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// reloadDelay is how long the watcher waits for the defaults directory to
+// settle before reloading. Updates to a mounted ConfigMap arrive as a burst of
+// events as the kubelet swaps the underlying symlinks.
+const reloadDelay = 2 * time.Second
+
+// reloadKey is the single item of the reload queue, so that the reloads of a
+// burst of events are merged and run one at a time
+const reloadKey = "reload"
+
+// dirWatcher is a watch that more directories can be added to
+type dirWatcher interface {
+	Add(path string) error
+}
+
+// reloader reloads the global definitions in response to filesystem events
+type reloader struct {
+	logger   *logrus.Logger
+	onReload func(context.Context) error
+	// watcher is the watch on the directories of the tree. Directories that
+	// are created later are added to it, as the watch is not recursive.
+	watcher dirWatcher
+	// queue holds the pending reload. It is processed by a single worker,
+	// and a reload that fails is requeued with an exponential backoff.
+	queue workqueue.TypedRateLimitingInterface[string]
+	// pending is set while reloaded definitions have not been applied by
+	// onReload yet. It is only accessed by the worker.
+	pending bool
+}
+
+// NewWatcher returns a Runnable that monitors Dir for changes and reloads the
+// global definitions whenever its contents change. onReload is called after a
+// new, valid set of definitions has been swapped in, and is called again
+// until it succeeds. Running it through the manager ensures that the caches
+// onReload reads from have been started.
+func NewWatcher(logger *logrus.Logger, onReload func(context.Context) error) (manager.Runnable, error) {
+	if Dir == "" {
+		return nil, errors.New("cannot watch the defaults directory as it has not been specified")
+	}
+	return &reloader{
+		logger:   logger,
+		onReload: onReload,
+		queue:    workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+	}, nil
+}
+
+// Start watches Dir and processes the reloads until the context is done
+func (r *reloader) Start(ctx context.Context) error {
+	defer r.queue.ShutDown()
+
+	// The watch is not recursive, so every directory in the tree is watched
+	dirs, err := manifestDirs(Dir)
+	if err != nil {
+		return err
+	}
+	watcher, err := filemonitor.NewWatch(r.logger, dirs, r.handleFilesystemUpdate)
+	if err != nil {
+		return err
+	}
+	r.watcher = watcher
+	watcher.Run(ctx)
+
+	go func() {
+		<-ctx.Done()
+		r.queue.ShutDown()
+	}()
+	for r.processNext(ctx) {
+	}
+	return nil
+}
+
+// processNext runs the next reload, and requeues it if it fails. It returns
+// false once the queue is shut down.
+func (r *reloader) processNext(ctx context.Context) bool {
+	key, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	if err := r.reload(ctx); err != nil {
+		r.logger.Errorf("[defaults] Failed to reload the default CatalogSources from %s, retrying - %v", Dir, err)
+		r.queue.AddRateLimited(key)
+		return true
+	}
+	r.queue.Forget(key)
+	return true
+}
+
+// manifestDirs returns dir and all the non-hidden directories below it,
+// including the symlinked ones
+func manifestDirs(dir string) ([]string, error) {
+	dirs := []string{dir}
+	err := walkTree(dir, func(fileName string, isDir bool) error {
+		if isDir {
+			dirs = append(dirs, filepath.Join(dir, fileName))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// handleFilesystemUpdate is the OnUpdateFn for the watcher. It schedules a
+// reload, so that a burst of events results in a single reload. A directory
+// that is created is watched right away, so that the files written to it
+// before the reload are not missed.
+func (r *reloader) handleFilesystemUpdate(logger *logrus.Logger, event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+	if event.Has(fsnotify.Create) {
+		r.watchDirs(logger, event.Name)
+	}
+	r.queue.AddAfter(reloadKey, reloadDelay)
+}
+
+// reload swaps in the definitions found on disk and calls onReload if they
+// have changed, or if it failed for the previous definitions.
+func (r *reloader) reload(ctx context.Context) error {
+	// Directories nested in a created directory may have been created before
+	// it was watched, so the whole tree is watched again before reloading
+	r.watchDirs(r.logger, Dir)
+	changed, err := ReloadGlobals()
+	if err != nil {
+		return fmt.Errorf("the current definitions are kept: %w", err)
+	}
+	if changed {
+		r.logger.Infof("[defaults] Reloaded default CatalogSource definitions from %s", Dir)
+		r.pending = true
+	} else {
+		r.logger.Debugf("[defaults] Default CatalogSource definitions in %s are unchanged", Dir)
+	}
+
+	if !r.pending || r.onReload == nil {
+		return nil
+	}
+	if err := r.onReload(ctx); err != nil {
+		return fmt.Errorf("failed to apply the reloaded definitions: %w", err)
+	}
+	r.pending = false
+	return nil
+}
+
+// watchDirs adds path to the watch if it is a directory, along with all the
+// directories below it
+func (r *reloader) watchDirs(logger *logrus.Logger, path string) {
+	if r.watcher == nil {
+		return
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return
+	}
+	if path != Dir && strings.HasPrefix(filepath.Base(path), ".") {
+		return
+	}
+
+	dirs, err := manifestDirs(path)
+	if err != nil {
+		logger.Warnf("[defaults] Failed to list the directories in %s - %v", path, err)
+	}
+	for _, dir := range dirs {
+		if err := r.watcher.Add(dir); err != nil {
+			logger.Warnf("[defaults] Failed to watch %s for changes to the default CatalogSources - %v", dir, err)
+		}
+	}
+}
@@ -42,6 +42,15 @@ func NewWatch(logger *logrus.Logger, pathsToWatch []string, onUpdateFn func(*log
 	return newWatcher, nil
 }
 
+// Add starts monitoring another path (non-recursive if a directory is added)
+func (w *watcher) Add(path string) error {
+	if err := w.notify.Add(path); err != nil {
+		return err
+	}
+	w.logger.Debugf("monitoring path '%v'", path)
+	return nil
+}
+
 func (w *watcher) Run(ctx context.Context) {
 	go func(ctx context.Context) {
 		for {
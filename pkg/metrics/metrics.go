@@ -9,6 +9,7 @@ import (
 	"github.com/operator-framework/operator-marketplace/pkg/filemonitor"
 
 	"github.com/operator-framework/operator-lifecycle-manager/pkg/lib/apiserver"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
@@ -24,6 +25,78 @@ const (
 	MetricsTLSPort = 8081
 )
 
+var (
+	// DefaultDefinitionLoadErrors reports the files in the defaults directory
+	// that could not be loaded.
+	DefaultDefinitionLoadErrors = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "marketplace_default_catalog_definition_load_errors",
+			Help: "Set to 1 for each file in the defaults directory that failed to load.",
+		},
+		[]string{"file"},
+	)
+
+	// DefaultCatalogRestores counts the fields of the default CatalogSources
+	// that were changed on the cluster and restored to their definition.
+	DefaultCatalogRestores = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "marketplace_default_catalog_restores_total",
+			Help: "Number of times a field of a default CatalogSource was restored after being changed on the cluster.",
+		},
+		[]string{"source", "field"},
+	)
+
+	// DefaultCatalogDryRunActions reports the actions that would be taken on
+	// the default CatalogSources in dry-run mode.
+	DefaultCatalogDryRunActions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "marketplace_default_catalog_dry_run_actions",
+			Help: "Set to 1 for each action that would be taken on a default CatalogSource in dry-run mode.",
+		},
+		[]string{"source", "action"},
+	)
+
+	// DefaultCatalogOrphans reports the CatalogSources that are annotated as
+	// managed by the operator but no longer have a default definition.
+	DefaultCatalogOrphans = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "marketplace_default_catalog_orphans",
+			Help: "Set to 1 for each CatalogSource managed by the operator that no longer has a default definition.",
+		},
+		[]string{"source"},
+	)
+
+	// DefaultCatalogEnsureFailures counts the default CatalogSources that
+	// could not be ensured once their retries were exhausted, by the class of
+	// the last error.
+	DefaultCatalogEnsureFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "marketplace_default_catalog_ensure_failures_total",
+			Help: "Number of times a default CatalogSource could not be ensured after retrying, by error class.",
+		},
+		[]string{"source", "class"},
+	)
+
+	// DefaultCatalogPodFailures reports the diagnosed failure of the pods of
+	// each default CatalogSource that is not serving.
+	DefaultCatalogPodFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "marketplace_default_catalog_pod_failure",
+			Help: "Set to 1 with the category of the failure of the pods of a default CatalogSource that is not serving.",
+		},
+		[]string{"source", "category"},
+	)
+
+	// DefaultCatalogLastSuccessfulResync reports when every default
+	// CatalogSource was last resynced successfully.
+	DefaultCatalogLastSuccessfulResync = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "marketplace_default_catalog_last_successful_resync_timestamp_seconds",
+			Help: "Unix time of the last periodic resync in which every default CatalogSource was ensured successfully.",
+		},
+	)
+)
+
 // ServePrometheus enables marketplace to serve prometheus metrics.
 func ServePrometheus(cert, key string, clientCAStore *certificateauthority.ClientCAStore, apiServerTLSQuerier apiserver.Querier) error {
 	// Register metrics for the operator with the prometheus.
@@ -108,6 +181,20 @@ func ServePrometheus(cert, key string, clientCAStore *certificateauthority.Clien
 // registerMetrics registers marketplace prometheus metrics.
 func registerMetrics() error {
 	// Register all of the metrics in the standard registry.
+	collectors := []prometheus.Collector{
+		DefaultDefinitionLoadErrors,
+		DefaultCatalogRestores,
+		DefaultCatalogDryRunActions,
+		DefaultCatalogOrphans,
+		DefaultCatalogEnsureFailures,
+		DefaultCatalogPodFailures,
+		DefaultCatalogLastSuccessfulResync,
+	}
+	for _, collector := range collectors {
+		if err := prometheus.Register(collector); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,170 @@
+package operatorhub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigMapName is the name of the ConfigMap in the operator's namespace that
+// holds the OperatorHub configuration on clusters without the config API. It
+// is handled like the cluster OperatorHub, including the annotations for the
+// overrides and the dry-run mode.
+const ConfigMapName = "marketplace-operatorhub"
+
+const (
+	// ConfigMapSpecKey is the key of the ConfigMap that holds the spec of the
+	// OperatorHub, as YAML or JSON
+	ConfigMapSpecKey = "spec"
+	// ConfigMapStatusKey is the key of the ConfigMap that the operator writes
+	// the status of the OperatorHub to, as YAML
+	ConfigMapStatusKey = "status"
+)
+
+var (
+	// configMapReader reads the OperatorHub ConfigMap, it is nil until the
+	// ConfigMap is used
+	configMapReader    client.Reader
+	configMapNamespace string
+	configMapLock      sync.RWMutex
+)
+
+// UseConfigMap makes the OperatorHub ConfigMap in the given namespace the
+// source of the configuration, read with reader. It is used on clusters
+// without the config API.
+func UseConfigMap(reader client.Reader, namespace string) {
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+	configMapReader = reader
+	configMapNamespace = namespace
+}
+
+// getConfigMap returns the OperatorHub ConfigMap, or nil if it is not used
+// or not present
+func getConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+	configMapLock.RLock()
+	reader, namespace := configMapReader, configMapNamespace
+	configMapLock.RUnlock()
+	if reader == nil {
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := reader.Get(ctx, client.ObjectKey{Name: ConfigMapName, Namespace: namespace}, cm); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return cm, nil
+}
+
+// ParseConfigMap returns the OperatorHub held by the given ConfigMap. The
+// spec is parsed strictly, so that a misspelled field is reported rather than
+// ignored. A status that cannot be parsed is left empty to be rewritten.
+func ParseConfigMap(cm *corev1.ConfigMap) (*configv1.OperatorHub, error) {
+	in := &configv1.OperatorHub{
+		TypeMeta: metav1.TypeMeta{Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        cm.Name,
+			Namespace:   cm.Namespace,
+			Annotations: cm.Annotations,
+		},
+	}
+	if err := yaml.Unmarshal([]byte(cm.Data[ConfigMapStatusKey]), &in.Status); err != nil {
+		in.Status = configv1.OperatorHubStatus{}
+	}
+	if err := yaml.UnmarshalStrict([]byte(cm.Data[ConfigMapSpecKey]), &in.Spec); err != nil {
+		return in, fmt.Errorf("invalid %s key of ConfigMap %s: %w", ConfigMapSpecKey, cm.Name, err)
+	}
+	return in, nil
+}
+
+// HandleConfigMap applies the configuration held by the OperatorHub ConfigMap
+// and writes its status back to the ConfigMap. Like Handle, it returns how
+// long after the ConfigMap has to be handled again.
+func HandleConfigMap(ctx context.Context, c client.Client, recorder events.EventRecorder, cm *corev1.ConfigMap) (time.Duration, error) {
+	_, requeueAfter, err := handleConfigMap(ctx, c, recorder, cm)
+	return requeueAfter, err
+}
+
+// handleConfigMap applies the configuration held by the OperatorHub ConfigMap
+// like handle does for the cluster OperatorHub. A spec that cannot be parsed
+// is reported in the status message of every default CatalogSource and
+// returned with the failures, and the last configuration is kept.
+func handleConfigMap(ctx context.Context, c client.Client, recorder events.EventRecorder, cm *corev1.ConfigMap) ([]error, time.Duration, error) {
+	in, specErr := ParseConfigMap(cm)
+	if specErr != nil {
+		logrus.Errorf("[operatorhub] Keeping the last configuration of the default CatalogSources - %v", specErr)
+	}
+
+	h := &confighandler{client: c, recorder: recorder, configMap: cm, specErr: specErr}
+	failures, requeueAfter, err := h.handle(ctx, in)
+	if specErr != nil {
+		failures = append(failures, specErr)
+	}
+	return failures, requeueAfter, err
+}
+
+// HandleConfigMapDeletion is called when the OperatorHub ConfigMap is not
+// found. Unless it was recreated since, the configuration it held no longer
+// applies, so the in-memory configuration is reset to the defaults and
+// reapplied to the default CatalogSources. Like Refresh, it returns how long
+// after it has to be called again.
+func HandleConfigMapDeletion(ctx context.Context, c client.Client, recorder events.EventRecorder) (time.Duration, error) {
+	cm, err := getConfigMap(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if cm == nil && GetSingleton().Reset() {
+		logrus.Infof("[operatorhub] The %s ConfigMap was deleted, resetting the default CatalogSources to the default configuration", ConfigMapName)
+	}
+	return Refresh(ctx, c, recorder)
+}
+
+// writeConfigMapStatus writes the given status to the status key of the
+// ConfigMap, unless it already holds it. Only the status key is patched, on
+// the version of the ConfigMap the status was computed from. On a conflict
+// the ConfigMap is read again, and the status is written to it unless its
+// configuration changed, which is then handled on its own.
+func writeConfigMapStatus(ctx context.Context, c client.Client, cm *corev1.ConfigMap, status configv1.OperatorHubStatus) error {
+	data, err := yaml.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	base := cm
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if base.Data[ConfigMapStatusKey] == string(data) {
+			return nil
+		}
+		updated := base.DeepCopy()
+		if updated.Data == nil {
+			updated.Data = make(map[string]string)
+		}
+		updated.Data[ConfigMapStatusKey] = string(data)
+		err := c.Patch(ctx, updated, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{}))
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+
+		latest, getErr := getConfigMap(ctx)
+		if getErr != nil {
+			return getErr
+		}
+		if latest == nil || latest.Data[ConfigMapSpecKey] != base.Data[ConfigMapSpecKey] || !equality.Semantic.DeepEqual(latest.Annotations, base.Annotations) {
+			return nil
+		}
+		base = latest
+		return err
+	})
+}
@@ -0,0 +1,40 @@
+package operatorhub
+
+import (
+	"context"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/operator-framework/operator-marketplace/pkg/status"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DeletedReason is the reason reported in the Available condition of the
+// ClusterOperator while the cluster OperatorHub does not exist. It does not
+// degrade the operator.
+const DeletedReason string = "OperatorHubDeleted"
+
+// HandleDeletion is called when the cluster OperatorHub is not found. Unless
+// it was recreated since, the configuration it held no longer applies, so the
+// in-memory configuration is reset to the defaults and reapplied to the
+// default CatalogSources. The deletion is reported in the ClusterOperator
+// until the OperatorHub is handled again. Like Refresh, it returns how long
+// after it has to be called again.
+func HandleDeletion(ctx context.Context, c client.Client, recorder events.EventRecorder) (time.Duration, error) {
+	err := c.Get(ctx, client.ObjectKey{Name: DefaultName}, &configv1.OperatorHub{})
+	if err == nil {
+		return Refresh(ctx, c, recorder)
+	}
+	if !apierrors.IsNotFound(err) {
+		return 0, err
+	}
+
+	if GetSingleton().Reset() {
+		logrus.Warnf("[operatorhub] The %s OperatorHub was deleted, resetting the default CatalogSources to the default configuration", DefaultName)
+	}
+	status.SetNotice(DeletedReason, "The cluster OperatorHub does not exist. The default CatalogSources are reconciled with the default configuration until it is recreated.")
+	return Refresh(ctx, c, recorder)
+}
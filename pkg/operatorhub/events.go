@@ -0,0 +1,37 @@
+package operatorhub
+
+import (
+	"context"
+
+	configv1 "github.com/openshift/api/config/v1"
+	mktconfig "github.com/operator-framework/operator-marketplace/pkg/apis/config/v1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetEventTarget returns the cluster OperatorHub, or the OperatorHub
+// ConfigMap on clusters without the config API, that the events about the
+// default CatalogSources are also recorded on. It returns nil if there is
+// neither. It is looked up on every event, so c is expected to read from a
+// cache rather than the API server.
+func GetEventTarget(ctx context.Context, c client.Reader) runtime.Object {
+	if !mktconfig.IsAPIAvailable() {
+		cm, err := getConfigMap(ctx)
+		if err != nil {
+			logrus.Warnf("Unable to get the %s ConfigMap to record events on - %v", ConfigMapName, err)
+		}
+		if cm == nil {
+			return nil
+		}
+		return cm
+	}
+	in := &configv1.OperatorHub{}
+	if err := c.Get(ctx, client.ObjectKey{Name: DefaultName}, in); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			logrus.Warnf("Unable to get the cluster OperatorHub to record events on - %v", err)
+		}
+		return nil
+	}
+	return in
+}
@@ -2,62 +2,209 @@ package operatorhub
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	configv1 "github.com/openshift/api/config/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
 	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"github.com/operator-framework/operator-marketplace/pkg/status"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// NewHandler returns a new Handler
-func NewHandler(client client.Client) Handler {
+// NewHandler returns a new Handler. Events about the default CatalogSources
+// are recorded with recorder.
+func NewHandler(client client.Client, recorder events.EventRecorder) Handler {
 	return &confighandler{
-		client: client,
+		client:   client,
+		recorder: recorder,
 	}
 }
 
-// Handler is the interface that wraps the Handle method
+// Handler is the interface that wraps the Handle method. Handle returns how
+// long after the OperatorHub has to be handled again for the default
+// CatalogSources that asked to be ensured again, 0 if none did.
 type Handler interface {
-	Handle(context.Context, *configv1.OperatorHub) error
+	Handle(context.Context, *configv1.OperatorHub) (time.Duration, error)
 }
 
 type confighandler struct {
-	client client.Client
+	client   client.Client
+	recorder events.EventRecorder
+	// configMap is the ConfigMap the configuration was read from on clusters
+	// without the config API. It is nil for the cluster OperatorHub.
+	configMap *corev1.ConfigMap
+	// specErr is set when the spec key of the ConfigMap cannot be parsed, the
+	// last configuration is then kept
+	specErr error
+}
+
+// eventTarget returns the object that the events about the default
+// CatalogSources are also recorded on
+func (h *confighandler) eventTarget(in *configv1.OperatorHub) runtime.Object {
+	if h.configMap != nil {
+		return h.configMap
+	}
+	return in
+}
+
+// writeStatus writes the status of the configuration to the object it was
+// read from
+func (h *confighandler) writeStatus(ctx context.Context, in *configv1.OperatorHub) error {
+	if h.configMap != nil {
+		return writeConfigMapStatus(ctx, h.client, h.configMap, in.Status)
+	}
+	return h.client.Status().Update(ctx, in)
 }
 
 // Handle handles events associated with the OperatorHub type.
-func (h *confighandler) Handle(ctx context.Context, in *configv1.OperatorHub) error {
+func (h *confighandler) Handle(ctx context.Context, in *configv1.OperatorHub) (time.Duration, error) {
+	_, requeueAfter, err := h.handle(ctx, in)
+	return requeueAfter, err
+}
+
+// handle applies the configuration of the OperatorHub and updates its status.
+// The failures to ensure the default CatalogSources are reported in the status
+// and returned along with the shortest delay after which one of them asked to
+// be ensured again, err is only set if the status could not be updated.
+func (h *confighandler) handle(ctx context.Context, in *configv1.OperatorHub) (failures []error, requeueAfter time.Duration, err error) {
 	log := logrus.WithFields(logrus.Fields{
 		"type": in.TypeMeta.Kind,
 		"name": in.GetName(),
 	})
 
+	// The cluster OperatorHub exists again
+	if h.configMap == nil {
+		status.ClearNotice(DeletedReason)
+	}
+
 	// Set the in memory configuration. This will be used by the CatalogSources reconcilers
 	current := GetSingleton()
-	current.Set(in.Spec)
+	if h.specErr == nil {
+		current.Set(in.Spec)
+	}
 	currentConfig := current.Get()
 
+	// An annotation that cannot be parsed is reported and no overrides are
+	// applied until it is fixed
+	overrides, overridesErr := defaults.ParseOverrides(in.GetAnnotations()[defaults.OverridesAnnotationKey])
+	if overridesErr != nil {
+		log.Errorf("Ignoring the overrides of the default CatalogSources - %v", overridesErr)
+	}
+	current.SetOverrides(overrides)
+
+	// An annotation that cannot be parsed enables the dry-run mode, so that
+	// nothing is changed until it is fixed
+	dryRun, dryRunErr := defaults.ParseDryRun(in.GetAnnotations()[defaults.DryRunAnnotationKey])
+	if dryRunErr != nil {
+		log.Errorf("Enabling the dry-run mode as the dry-run annotation cannot be parsed - %v", dryRunErr)
+	}
+	current.SetDryRun(dryRun)
+
 	// Apply the configuration to the default CatalogSources
 	catsrcDefinitions := defaults.GetGlobalCatalogSourceDefinitions()
-	result := defaults.New(catsrcDefinitions, currentConfig).EnsureAll(ctx, h.client)
+	companions := defaults.GetGlobalCompanions()
+	d := defaults.New(catsrcDefinitions, currentConfig,
+		defaults.WithCompanions(companions),
+		defaults.WithOverrides(overrides),
+		defaults.WithEventRecorder(h.recorder, h.eventTarget(in)),
+		defaults.WithDryRun(dryRun),
+	)
+	result := d.EnsureAll(ctx, h.client)
+
+	// A failure to sweep the orphaned CatalogSources is retried with the next
+	// event, it does not prevent the status from being updated
+	orphans, sweepErr := d.SweepOrphans(ctx, h.client)
+	failures, requeueAfter = ensureFailures(log, result, orphans, sweepErr)
 
-	if err := h.updateStatus(ctx, log, in, currentConfig, result); err != nil {
-		log.Errorf("Error updating cluster OperatorHub - %v", err)
-		return err
+	var annotationErrs []annotationError
+	if h.specErr != nil {
+		annotationErrs = append(annotationErrs, annotationError{ReasonInvalidSpec, fmt.Sprintf("the last configuration is kept as the %s key cannot be parsed - %v", ConfigMapSpecKey, h.specErr)})
 	}
-	return nil
+	if overridesErr != nil {
+		annotationErrs = append(annotationErrs, annotationError{ReasonInvalidAnnotation, fmt.Sprintf("no overrides are applied as the %s annotation cannot be parsed - %v", defaults.OverridesAnnotationKey, overridesErr)})
+	}
+	if dryRunErr != nil {
+		annotationErrs = append(annotationErrs, annotationError{ReasonInvalidAnnotation, fmt.Sprintf("the dry-run mode is enabled as the %s annotation cannot be parsed - %v", defaults.DryRunAnnotationKey, dryRunErr)})
+	}
+
+	if err := h.updateStatus(ctx, log, in, currentConfig, overrides, annotationErrs, result, orphans); err != nil {
+		log.Errorf("Error updating the status of the OperatorHub configuration - %v", err)
+		return failures, requeueAfter, err
+	}
+	return failures, requeueAfter, nil
+}
+
+// ensureFailures logs and returns the errors that prevented the default
+// CatalogSources from being ensured, or the orphaned ones from being swept,
+// along with the shortest delay after which one of the CatalogSources asked
+// to be ensured again. It is 0 if none of them did.
+func ensureFailures(log *logrus.Entry, result map[string]defaults.Result, orphans []defaults.Orphan, sweepErr error) ([]error, time.Duration) {
+	var failures []error
+	var requeueAfter time.Duration
+	names := make([]string, 0, len(result))
+	for name := range result {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := result[name]
+		if r.Err != nil {
+			log.Errorf("Error ensuring the default CatalogSource %s - %v", name, r.Err)
+			failures = append(failures, r.Err)
+		}
+		if r.Action == defaults.ActionContested {
+			failures = append(failures, fmt.Errorf("CatalogSource %s is contested by %s", name, strings.Join(r.ContestedBy, ", ")))
+		}
+		if r.RequeueAfter > 0 && (requeueAfter == 0 || r.RequeueAfter < requeueAfter) {
+			requeueAfter = r.RequeueAfter
+		}
+	}
+	if sweepErr != nil {
+		log.Errorf("Error sweeping the orphaned default CatalogSources - %v", sweepErr)
+		failures = append(failures, sweepErr)
+	}
+	for _, orphan := range orphans {
+		if orphan.Err != nil {
+			log.Errorf("Error sweeping the orphaned default CatalogSource %s - %v", orphan.Name, orphan.Err)
+			failures = append(failures, orphan.Err)
+		}
+	}
+	return failures, requeueAfter
+}
+
+// annotationError is an annotation of the OperatorHub, or the spec key of the
+// OperatorHub ConfigMap, that could not be parsed. It is reported with its
+// reason code in the status message of every default CatalogSource.
+type annotationError struct {
+	reason  string
+	message string
 }
 
-// updateStatus reflects the current state of applying the configuration into
-// status subresource of the object.
+// updateStatus reflects the current state of applying the configuration, and
+// the health of the default CatalogSources it was applied to, into the status
+// subresource of the object. The sources are sorted by name and the status is
+// only written when it changed.
 func (h *confighandler) updateStatus(
 	ctx context.Context,
 	log *logrus.Entry,
 	in *configv1.OperatorHub,
 	currentConfig map[string]bool,
-	result map[string]error,
+	overrides map[string]defaults.Override,
+	annotationErrs []annotationError,
+	result map[string]defaults.Result,
+	orphans []defaults.Orphan,
 ) error {
 	var statuses []configv1.HubSourceStatus
+	definitions := defaults.GetGlobalCatalogSourceDefinitions()
 	for name, disabled := range currentConfig {
 		status := configv1.HubSourceStatus{}
 		status.Name = name
@@ -65,24 +212,162 @@ func (h *confighandler) updateStatus(
 
 		// Check if there were any errors in the processing of actual default CatalogSources
 		if defaults.IsDefaultSource(name) {
-			err, present := result[name]
-			if !present {
+			r := result[name]
+			if r.Err == nil {
+				var messages []string
 				status.Status = "Success"
-				status.Message = ""
+				switch {
+				case r.Action == defaults.ActionUnmanaged:
+					status.Status = "Unmanaged"
+					messages = append(messages, reasonMessage(ReasonUnmanaged, "the CatalogSource is annotated with %s=true and is not managed by the operator", defaults.UnmanagedAnnotationKey))
+				case r.Action == defaults.ActionConflict && disabled:
+					status.Status = "Conflict"
+					messages = append(messages, reasonMessage(ReasonNotOwned, "the CatalogSource is disabled but is left in place as it was not created by the operator"))
+				case r.Action == defaults.ActionConflict:
+					status.Status = "Conflict"
+					messages = append(messages, reasonMessage(ReasonNotAdopted, "the CatalogSource was not created by the operator and is not adopted as the adoption policy is %s", defaults.DefaultsAdoptionPolicy))
+				case r.Action == defaults.ActionContested:
+					status.Status = "Error"
+					messages = append(messages, reasonMessage(ReasonContested, "the CatalogSource keeps being changed by %s, restoring it is backed off", strings.Join(r.ContestedBy, ", ")))
+				case r.Action == defaults.ActionFieldConflict:
+					status.Status = "Conflict"
+					messages = append(messages, reasonMessage(ReasonFieldConflict, "fields owned by other field managers are not taken over as the conflict policy is %s: %s", defaults.DefaultsConflictPolicy, strings.Join(r.Conflicts, "; ")))
+				case r.Action == defaults.ActionWaitForDeletion:
+					status.Status = "Terminating"
+					messages = append(messages, reasonMessage(ReasonTerminating, "the CatalogSource is being deleted and is recreated once it is gone"))
+				case r.DryRun && r.Action != defaults.ActionNone:
+					status.Status = "DryRun"
+					messages = append(messages, reasonMessage(ReasonDryRun, "would %s the CatalogSource", strings.ToLower(string(r.Action))))
+				case disabled:
+					defaults.ClearDiagnosis(name)
+				default:
+					status.Status, messages = h.catalogHealth(ctx, log, in, definitions[name])
+				}
+				if override, overridden := overrides[name]; overridden && !disabled {
+					messages = append(messages, reasonMessage(ReasonOverridden, "%s", strings.Join(override.Fields(), ", ")))
+				}
+				status.Message = strings.Join(messages, "; ")
 			} else {
 				status.Status = "Error"
-				status.Message = err.Error()
+				status.Message = reasonMessage(errorReason(r.ErrorClass), "failed after %d attempts: %v", r.Attempts, r.Err)
 			}
+		} else if reason, notApplicable := defaults.GetNotApplicableReason(name); notApplicable {
+			// The default CatalogSource does not apply to the cluster version
+			status.Status = "NotApplicable"
+			status.Message = reason
 		} else {
 			// A non-default or non-existent CatalogSources was present in the spec
 			status.Status = "Error"
 			status.Message = "Not present in the default definitions"
+			if loadErrors := defaults.GetLoadErrors(); len(loadErrors) > 0 {
+				status.Message = fmt.Sprintf("%s, %d of the files with default definitions failed to load", status.Message, len(loadErrors))
+			}
 		}
 		statuses = append(statuses, status)
 	}
-	in.Status = configv1.OperatorHubStatus{Sources: statuses}
 
-	// The first status update will result in another event as there as been a
-	// change to the object. The second update will be a no-op.
-	return h.client.Status().Update(ctx, in)
+	// The annotations and spec that could not be parsed apply to every
+	// default CatalogSource, so they are reported in the message of each of
+	// them
+	for i := range statuses {
+		if !defaults.IsDefaultSource(statuses[i].Name) {
+			continue
+		}
+		messages := []string{}
+		if statuses[i].Message != "" {
+			messages = append(messages, statuses[i].Message)
+		}
+		for _, annotationErr := range annotationErrs {
+			messages = append(messages, reasonMessage(annotationErr.reason, "%s", annotationErr.message))
+		}
+		statuses[i].Message = strings.Join(messages, "; ")
+	}
+
+	// Report the overrides that do not belong to a default CatalogSource
+	var unknown []string
+	for name := range overrides {
+		if _, present := currentConfig[name]; !present {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		status := configv1.HubSourceStatus{}
+		status.Name = name
+		status.Status = "Error"
+		status.Message = "Overrides set for a CatalogSource that is not present in the default definitions"
+		statuses = append(statuses, status)
+	}
+
+	// Report the orphaned CatalogSources that were kept. The ones that are
+	// kept silently by policy are only logged. An orphan that is also named
+	// in the spec replaces its entry.
+	index := make(map[string]int, len(statuses))
+	for i, status := range statuses {
+		index[status.Name] = i
+	}
+	for _, orphan := range orphans {
+		status := configv1.HubSourceStatus{}
+		status.Name = orphan.Name
+		switch {
+		case orphan.Err != nil:
+			status.Status = "Error"
+			status.Message = orphan.Err.Error()
+		case orphan.Action == defaults.ActionDelete:
+			status.Status = "DryRun"
+			status.Message = "Dry run: would delete the CatalogSource as it no longer has a default definition"
+		case defaults.OrphanedDefaultsPolicy == defaults.OrphanPolicyKeep:
+			continue
+		default:
+			status.Status = "Orphaned"
+			status.Message = fmt.Sprintf("The CatalogSource no longer has a default definition and is kept as %s", orphan.Reason)
+		}
+		if i, present := index[orphan.Name]; present {
+			status.Disabled = statuses[i].Disabled
+			statuses[i] = status
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	// Writing the status results in another event for the object, which must
+	// not write it again
+	newStatus := configv1.OperatorHubStatus{Sources: statuses}
+	if equality.Semantic.DeepEqual(in.Status, newStatus) {
+		return nil
+	}
+	in.Status = newStatus
+	return h.writeStatus(ctx, in)
+}
+
+// catalogHealth returns the status and messages of an enabled default
+// CatalogSource that was applied successfully, based on its health on the
+// cluster. The pods of a CatalogSource that is not serving are diagnosed, and
+// a known failure is reported first.
+func (h *confighandler) catalogHealth(ctx context.Context, log *logrus.Entry, in *configv1.OperatorHub, def olmv1alpha1.CatalogSource) (string, []string) {
+	catsrc := &olmv1alpha1.CatalogSource{}
+	if err := h.client.Get(ctx, client.ObjectKey{Name: def.Name, Namespace: def.Namespace}, catsrc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Errorf("Error getting CatalogSource %s - %v", def.Name, err)
+		}
+		return "Pending", []string{reasonMessage(ReasonCatalogPending, "the CatalogSource has not been observed on the cluster yet")}
+	}
+	status, message := catalogHealth(catsrc, time.Now())
+	if status == "Success" || status == "Stale" {
+		defaults.ClearDiagnosis(def.Name)
+		return status, []string{message}
+	}
+
+	diagnosis, err := defaults.DiagnosePods(ctx, h.client, h.recorder, h.eventTarget(in), catsrc)
+	if err != nil {
+		log.Errorf("Error diagnosing CatalogSource %s - %v", def.Name, err)
+	}
+	if diagnosis == nil {
+		return status, []string{message}
+	}
+	// The pod name, kubelet message and restart count change while the
+	// failure lasts, they are only recorded in the event
+	return "Error", []string{reasonMessage(string(diagnosis.Failure), "%s", diagnosis.Summary()), message}
 }
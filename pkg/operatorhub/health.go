@@ -0,0 +1,142 @@
+package operatorhub
+
+import (
+	"fmt"
+	"time"
+
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// The reason codes the messages of the default CatalogSources in the
+// OperatorHub status start with. They are part of the API of the operator,
+// tooling may match on them.
+const (
+	// ReasonReady is reported when the registry of the CatalogSource is
+	// serving
+	ReasonReady = "Ready"
+	// ReasonCatalogPending is reported while the registry of the
+	// CatalogSource is not connected yet
+	ReasonCatalogPending = "CatalogPending"
+	// ReasonCatalogUnhealthy is reported when the registry of the
+	// CatalogSource cannot be connected to
+	ReasonCatalogUnhealthy = "CatalogUnhealthy"
+	// ReasonPollStale is reported when the registry of the CatalogSource has
+	// not been polled for updates for several of its poll intervals
+	ReasonPollStale = "PollStale"
+	// ReasonUnmanaged is reported when the CatalogSource is annotated as
+	// unmanaged
+	ReasonUnmanaged = "Unmanaged"
+	// ReasonNotAdopted is reported when the CatalogSource was not created by
+	// the operator and is not adopted
+	ReasonNotAdopted = "NotAdopted"
+	// ReasonNotOwned is reported when a disabled CatalogSource is left in
+	// place as it was not created by the operator
+	ReasonNotOwned = "NotOwned"
+	// ReasonContested is reported when restoring the CatalogSource is backed
+	// off as another field manager keeps changing it
+	ReasonContested = "Contested"
+	// ReasonFieldConflict is reported when fields of the CatalogSource or of
+	// its companion objects are owned by other field managers and are not
+	// taken over
+	ReasonFieldConflict = "FieldConflict"
+	// ReasonTerminating is reported while the CatalogSource is being deleted
+	// before it is recreated
+	ReasonTerminating = "Terminating"
+	// ReasonDryRun is reported when an action is pending in dry-run mode
+	ReasonDryRun = "DryRun"
+	// ReasonOverridden is reported when fields of the CatalogSource are
+	// overridden
+	ReasonOverridden = "Overridden"
+	// ReasonInvalidAnnotation is reported when an annotation of the
+	// OperatorHub that applies to every CatalogSource cannot be parsed
+	ReasonInvalidAnnotation = "InvalidAnnotation"
+	// ReasonInvalidSpec is reported when the spec key of the OperatorHub
+	// ConfigMap cannot be parsed
+	ReasonInvalidSpec = "InvalidSpec"
+)
+
+// stalePollIntervals is the number of poll intervals after which the last
+// registry poll of a CatalogSource is reported as stale
+const stalePollIntervals = 3
+
+// errorReason returns the reason code of a CatalogSource that could not be
+// ensured because of an error of the given class
+func errorReason(class defaults.ErrorClass) string {
+	return string(class) + "Error"
+}
+
+// reasonMessage returns a status message that starts with the given reason
+// code
+func reasonMessage(reason, format string, args ...interface{}) string {
+	return reason + ": " + fmt.Sprintf(format, args...)
+}
+
+// connectionState returns the last observed state of the registry connection
+// of the given CatalogSource, or an empty string if it is not known yet
+func connectionState(catsrc *olmv1alpha1.CatalogSource) string {
+	if catsrc.Status.GRPCConnectionState == nil {
+		return ""
+	}
+	return catsrc.Status.GRPCConnectionState.LastObservedState
+}
+
+// ConnectionStateChanged returns true if the state of the registry connection
+// differs between the given versions of a CatalogSource
+func ConnectionStateChanged(old, new *olmv1alpha1.CatalogSource) bool {
+	return connectionState(old) != connectionState(new)
+}
+
+// HealthPredicate returns the predicate of the watches on the CatalogSources
+// that requeue the OperatorHub, or the OperatorHub ConfigMap. The status of
+// each default CatalogSource reflects the state of its registry connection,
+// so only the updates of the default CatalogSources that change it pass.
+func HealthPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, okOld := e.ObjectOld.(*olmv1alpha1.CatalogSource)
+			new, okNew := e.ObjectNew.(*olmv1alpha1.CatalogSource)
+			return okOld && okNew && defaults.IsDefaultSource(new.Name) && ConnectionStateChanged(old, new)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// catalogHealth returns the status and message of a default CatalogSource
+// that was applied successfully, based on the state of its registry
+// connection and its last registry poll. The messages carry no timestamps, so
+// that the status is only written when the health changes.
+func catalogHealth(catsrc *olmv1alpha1.CatalogSource, now time.Time) (string, string) {
+	switch state := connectionState(catsrc); state {
+	case "READY":
+	case "TRANSIENT_FAILURE", "SHUTDOWN":
+		lastConnect := catsrc.Status.GRPCConnectionState.LastConnectTime
+		if lastConnect.IsZero() {
+			return "Error", reasonMessage(ReasonCatalogUnhealthy, "the registry connection is %s and has never been established", state)
+		}
+		return "Error", reasonMessage(ReasonCatalogUnhealthy, "the registry connection is %s, it had been established before", state)
+	case "":
+		return "Pending", reasonMessage(ReasonCatalogPending, "the registry has not been connected to yet")
+	default:
+		return "Pending", reasonMessage(ReasonCatalogPending, "the registry connection is %s", state)
+	}
+
+	if poll := catsrc.Spec.UpdateStrategy; poll != nil && poll.RegistryPoll != nil && catsrc.Status.LatestImageRegistryPoll != nil {
+		interval, err := time.ParseDuration(poll.RegistryPoll.RawInterval)
+		latest := catsrc.Status.LatestImageRegistryPoll.Time
+		if err == nil && interval > 0 && now.Sub(latest) > stalePollIntervals*interval {
+			return "Stale", reasonMessage(ReasonPollStale, "the registry has not been polled for more than %d poll intervals of %s", stalePollIntervals, interval)
+		}
+	}
+	return "Success", reasonMessage(ReasonReady, "the registry connection is READY")
+}
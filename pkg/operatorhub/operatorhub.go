@@ -5,6 +5,7 @@ import (
 
 	configv1 "github.com/openshift/api/config/v1"
 	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"k8s.io/apimachinery/pkg/api/equality"
 )
 
 // DefaultName is the default name of the OperatorHub config resource on an
@@ -24,7 +25,16 @@ func init() {
 // operatorhub implements OperatorHub
 type operatorhub struct {
 	current map[string]bool
-	lock    sync.Mutex
+	// spec is the last spec the configuration was set from. It is used to
+	// recompute the configuration when the default definitions change.
+	spec configv1.OperatorHubSpec
+	// overrides are the overrides of the default CatalogSources set on the
+	// OperatorHub object
+	overrides map[string]defaults.Override
+	// dryRun is set when the dry-run mode is enabled on the OperatorHub
+	// object
+	dryRun bool
+	lock   sync.Mutex
 }
 
 // OperatorHub is the interface to interact with the OperatorHub configuration in
@@ -32,6 +42,12 @@ type operatorhub struct {
 type OperatorHub interface {
 	Get() map[string]bool
 	Set(spec configv1.OperatorHubSpec)
+	GetOverrides() map[string]defaults.Override
+	SetOverrides(overrides map[string]defaults.Override)
+	GetDryRun() bool
+	SetDryRun(dryRun bool)
+	Refresh()
+	Reset() bool
 	Disabled() bool
 }
 
@@ -47,6 +63,36 @@ func (o *operatorhub) Get() map[string]bool {
 	return o.current
 }
 
+// GetOverrides returns the current overrides of the default CatalogSources
+func (o *operatorhub) GetOverrides() map[string]defaults.Override {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.overrides
+}
+
+// SetOverrides sets the current overrides of the default CatalogSources
+func (o *operatorhub) SetOverrides(overrides map[string]defaults.Override) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.overrides = overrides
+}
+
+// GetDryRun returns true if the dry-run mode is enabled on the OperatorHub
+// object
+func (o *operatorhub) GetDryRun() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.dryRun
+}
+
+// SetDryRun sets whether the dry-run mode is enabled on the OperatorHub
+// object
+func (o *operatorhub) SetDryRun(dryRun bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.dryRun = dryRun
+}
+
 // Disabled returns true if all defaults are disabled
 func (o *operatorhub) Disabled() bool {
 	o.lock.Lock()
@@ -68,6 +114,37 @@ func (o *operatorhub) Set(spec configv1.OperatorHubSpec) {
 	o.lock.Lock()
 	defer o.lock.Unlock()
 
+	o.spec = *spec.DeepCopy()
+	o.set(spec)
+}
+
+// Refresh recomputes the current configuration from the last spec that was
+// set. It needs to be called when the default definitions have changed.
+func (o *operatorhub) Refresh() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.set(o.spec)
+}
+
+// Reset drops the spec, overrides and dry-run mode set from the OperatorHub
+// object, so that the default configuration applies again. It is called when
+// the OperatorHub object is deleted. It returns true if any of them was set.
+func (o *operatorhub) Reset() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	set := !equality.Semantic.DeepEqual(o.spec, configv1.OperatorHubSpec{}) || len(o.overrides) > 0 || o.dryRun
+	o.spec = configv1.OperatorHubSpec{}
+	o.overrides = nil
+	o.dryRun = false
+	o.set(o.spec)
+	return set
+}
+
+// set computes the current configuration from the spec. The caller is
+// expected to hold the lock.
+func (o *operatorhub) set(spec configv1.OperatorHubSpec) {
 	// Reset to the defaults. If DisableAllDefaultSources, mark all defaults
 	// as disabled.
 	o.current = make(map[string]bool)
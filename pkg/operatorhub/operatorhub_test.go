@@ -0,0 +1,643 @@
+package operatorhub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	olmv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	mktconfig "github.com/operator-framework/operator-marketplace/pkg/apis/config/v1"
+	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/operator-framework/operator-marketplace/pkg/status"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+const testNamespace = "openshift-marketplace"
+
+const testCatsrcDefinition = `apiVersion: operators.coreos.com/v1alpha1
+kind: CatalogSource
+metadata:
+  name: %s
+  namespace: openshift-marketplace
+spec:
+  sourceType: grpc
+  image: %s
+`
+
+// setupDefaults loads a redhat-operators default definition and restores the
+// global state of the package once the test is done
+func setupDefaults(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "01_redhat.yaml"), []byte(fmt.Sprintf(testCatsrcDefinition, "redhat-operators", "registry.io/redhat:v4.23")), 0644))
+	defaults.Dir = dir
+	require.NoError(t, defaults.PopulateGlobals(defaults.TemplateContext{WatchNamespace: testNamespace}))
+	GetSingleton().Reset()
+
+	t.Cleanup(func() {
+		defaults.Dir = ""
+		require.NoError(t, defaults.PopulateGlobals(defaults.TemplateContext{}))
+		GetSingleton().Reset()
+		UseConfigMap(nil, "")
+		mktconfig.SetAPIAvailable(false)
+	})
+}
+
+// newClientBuilder returns a fake client builder with the types handled by
+// the package
+func newClientBuilder(t *testing.T) *fake.ClientBuilder {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, configv1.AddToScheme(scheme))
+	require.NoError(t, olmv1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	return fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&configv1.OperatorHub{})
+}
+
+func gaugeValue(t *testing.T, gauge interface{ Write(*dto.Metric) error }) float64 {
+	t.Helper()
+	m := &dto.Metric{}
+	require.NoError(t, gauge.Write(m))
+	return m.GetGauge().GetValue()
+}
+
+// handled fails the test if applying the configuration failed or asked to be
+// requeued
+func handled(t *testing.T) func(time.Duration, error) {
+	return func(requeueAfter time.Duration, err error) {
+		t.Helper()
+		require.NoError(t, err)
+		assert.Zero(t, requeueAfter)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	setupDefaults(t)
+	c := newClientBuilder(t).Build()
+	ctx := context.TODO()
+	recorder := events.NewFakeRecorder(100)
+	key := client.ObjectKey{Name: "redhat-operators", Namespace: testNamespace}
+	present := func() bool {
+		return c.Get(ctx, key, &olmv1alpha1.CatalogSource{}) == nil
+	}
+
+	// Without an OperatorHub, the last known configuration is reapplied
+	mktconfig.SetAPIAvailable(true)
+	handled(t)(Refresh(ctx, c, recorder))
+	assert.True(t, present())
+
+	// The cluster OperatorHub is handled first, and its status written
+	hub := &configv1.OperatorHub{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+		Spec:       configv1.OperatorHubSpec{DisableAllDefaultSources: true},
+	}
+	require.NoError(t, c.Create(ctx, hub))
+	handled(t)(Refresh(ctx, c, recorder))
+	assert.False(t, present())
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: DefaultName}, hub))
+	require.Len(t, hub.Status.Sources, 1)
+	assert.Equal(t, "redhat-operators", hub.Status.Sources[0].Name)
+	assert.True(t, hub.Status.Sources[0].Disabled)
+
+	// Without the config API, the OperatorHub ConfigMap is handled instead
+	mktconfig.SetAPIAvailable(false)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: testNamespace},
+		Data:       map[string]string{ConfigMapSpecKey: "disableAllDefaultSources: false"},
+	}
+	require.NoError(t, c.Create(ctx, cm))
+	UseConfigMap(c, testNamespace)
+	handled(t)(Refresh(ctx, c, recorder))
+	assert.True(t, present())
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: ConfigMapName, Namespace: testNamespace}, cm))
+	assert.Contains(t, cm.Data[ConfigMapStatusKey], "name: redhat-operators")
+
+	// and the last known configuration is reapplied once it is gone
+	require.NoError(t, c.Delete(ctx, cm))
+	require.NoError(t, c.Delete(ctx, &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}))
+	handled(t)(Refresh(ctx, c, recorder))
+	assert.True(t, present())
+}
+
+func TestResync(t *testing.T) {
+	setupDefaults(t)
+	ctx := context.TODO()
+	failing := true
+	c := newClientBuilder(t).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(ctx context.Context, c client.WithWatch, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+			if failing {
+				return k8sErrors.NewBadRequest("rejected")
+			}
+			return c.Apply(ctx, obj, opts...)
+		},
+	}).Build()
+	lastSuccess := func() float64 {
+		return gaugeValue(t, metrics.DefaultCatalogLastSuccessfulResync)
+	}
+	metrics.DefaultCatalogLastSuccessfulResync.Set(0)
+	r := NewResync(c, events.NewFakeRecorder(100), time.Minute).(*resync)
+
+	// The last success is only updated once every default CatalogSource was
+	// ensured
+	r.run(ctx)
+	assert.True(t, r.lastSuccess.IsZero())
+	assert.Zero(t, lastSuccess())
+
+	failing = false
+	r.run(ctx)
+	assert.False(t, r.lastSuccess.IsZero())
+	assert.InDelta(t, float64(time.Now().Unix()), lastSuccess(), 5)
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "redhat-operators", Namespace: testNamespace}, &olmv1alpha1.CatalogSource{}))
+
+	// A failed resync leaves it as it is
+	before := lastSuccess()
+	failing = true
+	require.NoError(t, c.Delete(ctx, &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators", Namespace: testNamespace}}))
+	r.run(ctx)
+	assert.Equal(t, before, lastSuccess())
+
+	// The resync runs every interval until the context is done
+	failing = false
+	metrics.DefaultCatalogLastSuccessfulResync.Set(0)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- NewResync(c, events.NewFakeRecorder(100), 10*time.Millisecond).Start(ctx)
+	}()
+	require.Eventually(t, func() bool { return lastSuccess() > 0 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestRequeue(t *testing.T) {
+	setupDefaults(t)
+	mktconfig.SetAPIAvailable(true)
+	defer func(backoff wait.Backoff) { defaults.EnsureBackoff = backoff }(defaults.EnsureBackoff)
+	defaults.EnsureBackoff = wait.Backoff{Duration: time.Minute, Factor: 2, Steps: 4}
+	ctx := context.TODO()
+	failing := true
+	c := newClientBuilder(t).WithInterceptorFuncs(interceptor.Funcs{
+		Apply: func(ctx context.Context, c client.WithWatch, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+			if failing {
+				return k8sErrors.NewServiceUnavailable("unavailable")
+			}
+			return c.Apply(ctx, obj, opts...)
+		},
+	}).Build()
+	hub := &configv1.OperatorHub{ObjectMeta: metav1.ObjectMeta{Name: DefaultName}}
+	require.NoError(t, c.Create(ctx, hub))
+
+	// A source that failed with a retriable error requeues the OperatorHub
+	// with the backoff of the source, the failure is only reported in the
+	// status
+	requeueAfter, err := NewHandler(c, events.NewFakeRecorder(100)).Handle(ctx, hub)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, requeueAfter)
+
+	// Refresh returns the failure along with the delay, which the reconcile
+	// is requeued after rather than retried by the worker
+	requeueAfter, err = Refresh(ctx, c, events.NewFakeRecorder(100))
+	assert.ErrorContains(t, err, "unavailable")
+	assert.Equal(t, 2*time.Minute, requeueAfter)
+	result, err := Requeue(requeueAfter, err)
+	require.NoError(t, err)
+	assert.Equal(t, reconcile.Result{RequeueAfter: 2 * time.Minute}, result)
+	result, err = Requeue(0, k8sErrors.NewBadRequest("rejected"))
+	assert.True(t, k8sErrors.IsBadRequest(err))
+	assert.Zero(t, result)
+
+	// The resync runs again after the delay when it is shorter than its
+	// interval
+	r := NewResync(c, events.NewFakeRecorder(100), time.Hour).(*resync)
+	assert.Equal(t, 4*time.Minute, r.run(ctx))
+
+	// and no longer once the source is ensured
+	failing = false
+	assert.Equal(t, time.Hour, r.run(ctx))
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: DefaultName}, hub))
+	requeueAfter, err = NewHandler(c, events.NewFakeRecorder(100)).Handle(ctx, hub)
+	require.NoError(t, err)
+	assert.Zero(t, requeueAfter)
+}
+
+func TestHealthPredicate(t *testing.T) {
+	setupDefaults(t)
+	catsrc := func(name, state string) *olmv1alpha1.CatalogSource {
+		c := &olmv1alpha1.CatalogSource{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace}}
+		if state != "" {
+			c.Status.GRPCConnectionState = &olmv1alpha1.GRPCConnectionState{LastObservedState: state}
+		}
+		return c
+	}
+	pred := HealthPredicate()
+
+	// Only the updates of the default CatalogSources that change the state
+	// of their registry connection pass
+	assert.True(t, pred.Update(event.UpdateEvent{ObjectOld: catsrc("redhat-operators", ""), ObjectNew: catsrc("redhat-operators", "READY")}))
+	assert.False(t, pred.Update(event.UpdateEvent{ObjectOld: catsrc("redhat-operators", "READY"), ObjectNew: catsrc("redhat-operators", "READY")}))
+	assert.False(t, pred.Update(event.UpdateEvent{ObjectOld: catsrc("custom-operators", ""), ObjectNew: catsrc("custom-operators", "READY")}))
+	assert.False(t, pred.Create(event.CreateEvent{Object: catsrc("redhat-operators", "READY")}))
+	assert.False(t, pred.Delete(event.DeleteEvent{Object: catsrc("redhat-operators", "READY")}))
+	assert.False(t, pred.Generic(event.GenericEvent{Object: catsrc("redhat-operators", "READY")}))
+}
+
+func TestCatalogHealth(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	catsrc := func(state string, lastConnect time.Time, interval string, lastPoll time.Time) *olmv1alpha1.CatalogSource {
+		c := &olmv1alpha1.CatalogSource{}
+		if state != "" {
+			c.Status.GRPCConnectionState = &olmv1alpha1.GRPCConnectionState{LastObservedState: state, LastConnectTime: metav1.NewTime(lastConnect)}
+		}
+		if interval != "" {
+			c.Spec.UpdateStrategy = &olmv1alpha1.UpdateStrategy{RegistryPoll: &olmv1alpha1.RegistryPoll{RawInterval: interval}}
+			c.Status.LatestImageRegistryPoll = &metav1.Time{Time: lastPoll}
+		}
+		return c
+	}
+	tests := []struct {
+		name    string
+		catsrc  *olmv1alpha1.CatalogSource
+		status  string
+		message string
+	}{
+		{"ready", catsrc("READY", now, "", time.Time{}), "Success", "Ready: the registry connection is READY"},
+		{"ready and polled", catsrc("READY", now, "10m", now.Add(-20*time.Minute)), "Success", "Ready: the registry connection is READY"},
+		{"ready with an invalid poll interval", catsrc("READY", now, "often", now.Add(-time.Hour)), "Success", "Ready: the registry connection is READY"},
+		{"stale", catsrc("READY", now, "10m", now.Add(-time.Hour)), "Stale", "PollStale: the registry has not been polled for more than 3 poll intervals of 10m0s"},
+		{"not connected yet", catsrc("", time.Time{}, "", time.Time{}), "Pending", "CatalogPending: the registry has not been connected to yet"},
+		{"connecting", catsrc("CONNECTING", time.Time{}, "", time.Time{}), "Pending", "CatalogPending: the registry connection is CONNECTING"},
+		{"never connected", catsrc("TRANSIENT_FAILURE", time.Time{}, "", time.Time{}), "Error", "CatalogUnhealthy: the registry connection is TRANSIENT_FAILURE and has never been established"},
+		{"disconnected", catsrc("SHUTDOWN", now.Add(-time.Hour), "", time.Time{}), "Error", "CatalogUnhealthy: the registry connection is SHUTDOWN, it had been established before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, message := catalogHealth(tt.catsrc, now)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.message, message)
+
+			// The message does not change as time passes
+			_, message = catalogHealth(tt.catsrc, now.Add(time.Minute))
+			assert.Equal(t, tt.message, message)
+		})
+	}
+}
+
+func TestEnsureFailures(t *testing.T) {
+	result := map[string]defaults.Result{
+		"certified-operators": {Action: defaults.ActionCreate},
+		"community-operators": {Err: fmt.Errorf("unavailable"), ErrorClass: defaults.ErrorClassTransient, RequeueAfter: time.Minute},
+		"contested-operators": {Action: defaults.ActionContested, ContestedBy: []string{"gitops"}, RequeueAfter: 2 * time.Minute},
+		"redhat-operators":    {Err: fmt.Errorf("denied"), ErrorClass: defaults.ErrorClassDenied},
+	}
+	orphans := []defaults.Orphan{{Name: "old-operators", Err: fmt.Errorf("failed to delete")}, {Name: "kept-operators"}}
+	failures, requeueAfter := ensureFailures(logrus.NewEntry(logrus.StandardLogger()), result, orphans, fmt.Errorf("failed to list"))
+	var messages []string
+	for _, failure := range failures {
+		messages = append(messages, failure.Error())
+	}
+	assert.Equal(t, []string{"unavailable", "CatalogSource contested-operators is contested by gitops", "denied", "failed to list", "failed to delete"}, messages)
+	assert.Equal(t, time.Minute, requeueAfter)
+
+	failures, requeueAfter = ensureFailures(logrus.NewEntry(logrus.StandardLogger()), map[string]defaults.Result{"redhat-operators": {}}, nil, nil)
+	assert.Empty(t, failures)
+	assert.Zero(t, requeueAfter)
+}
+
+func TestUpdateStatusUnchanged(t *testing.T) {
+	setupDefaults(t)
+	ctx := context.TODO()
+	updates := 0
+	c := newClientBuilder(t).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			updates++
+			return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+		},
+	}).Build()
+	hub := &configv1.OperatorHub{ObjectMeta: metav1.ObjectMeta{Name: DefaultName}}
+	require.NoError(t, c.Create(ctx, hub))
+
+	h := &confighandler{client: c, recorder: events.NewFakeRecorder(10)}
+	log := logrus.WithField("name", DefaultName)
+	config := map[string]bool{"redhat-operators": true}
+	result := map[string]defaults.Result{"redhat-operators": {Action: defaults.ActionNone}}
+
+	require.NoError(t, h.updateStatus(ctx, log, hub, config, nil, nil, result, nil))
+	assert.Equal(t, 1, updates)
+	require.Len(t, hub.Status.Sources, 1)
+	assert.Equal(t, "Success", hub.Status.Sources[0].Status)
+
+	// The same outcome writes nothing, so the status update does not trigger
+	// another reconcile that writes it again
+	hub = &configv1.OperatorHub{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: DefaultName}, hub))
+	require.NoError(t, h.updateStatus(ctx, log, hub, config, nil, nil, result, nil))
+	assert.Equal(t, 1, updates)
+}
+
+func TestUpdateStatusDiagnosis(t *testing.T) {
+	setupDefaults(t)
+	ctx := context.TODO()
+	catsrc := &olmv1alpha1.CatalogSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators", Namespace: testNamespace},
+		Status: olmv1alpha1.CatalogSourceStatus{
+			GRPCConnectionState: &olmv1alpha1.GRPCConnectionState{LastObservedState: "TRANSIENT_FAILURE"},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "redhat-operators-abcde", Namespace: testNamespace, Labels: map[string]string{defaults.CatalogSourceLabelKey: "redhat-operators"}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "registry-server",
+			Image:        "registry.io/redhat:v4.23",
+			RestartCount: 3,
+			State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}},
+	}
+	updates := 0
+	c := newClientBuilder(t).WithObjects(catsrc, pod).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			updates++
+			return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+		},
+	}).Build()
+	hub := &configv1.OperatorHub{ObjectMeta: metav1.ObjectMeta{Name: DefaultName}}
+	require.NoError(t, c.Create(ctx, hub))
+	t.Cleanup(func() { defaults.ClearDiagnosis("redhat-operators") })
+
+	recorder := events.NewFakeRecorder(10)
+	h := &confighandler{client: c, recorder: recorder}
+	log := logrus.WithField("name", DefaultName)
+	config := map[string]bool{"redhat-operators": false}
+	result := map[string]defaults.Result{"redhat-operators": {Action: defaults.ActionNone}}
+
+	// The status only holds the failure and the failing container, the
+	// restart count is left to the event
+	require.NoError(t, h.updateStatus(ctx, log, hub, config, nil, nil, result, nil))
+	require.Len(t, hub.Status.Sources, 1)
+	assert.Equal(t, "Error", hub.Status.Sources[0].Status)
+	assert.Equal(t, "CrashLoop: container registry-server with the image registry.io/redhat:v4.23; CatalogUnhealthy: the registry connection is TRANSIENT_FAILURE and has never been established", hub.Status.Sources[0].Message)
+	require.Len(t, recorder.Events, 2, "the event is recorded on the CatalogSource and the OperatorHub")
+	assert.Contains(t, <-recorder.Events, "restarted 3 times")
+
+	// so the status is not written again as the container keeps restarting
+	pod.Status.ContainerStatuses[0].RestartCount = 4
+	require.NoError(t, c.Update(ctx, pod))
+	require.NoError(t, h.updateStatus(ctx, log, hub, config, nil, nil, result, nil))
+	assert.Equal(t, 1, updates)
+}
+
+func TestHandleDeletion(t *testing.T) {
+	setupDefaults(t)
+	mktconfig.SetAPIAvailable(true)
+	t.Cleanup(func() { status.ClearNotice(DeletedReason) })
+	c := newClientBuilder(t).Build()
+	ctx := context.TODO()
+	recorder := events.NewFakeRecorder(100)
+	key := client.ObjectKey{Name: "redhat-operators", Namespace: testNamespace}
+	deleted := func() bool {
+		_, present := status.Notice(DeletedReason)
+		return present
+	}
+
+	// Without an OperatorHub, the default configuration is applied and the
+	// missing OperatorHub is reported
+	handled(t)(HandleDeletion(ctx, c, recorder))
+	require.NoError(t, c.Get(ctx, key, &olmv1alpha1.CatalogSource{}))
+	assert.True(t, deleted())
+
+	// An OperatorHub that is found again is handled rather than reset, and
+	// is no longer reported
+	hub := &configv1.OperatorHub{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultName, Annotations: map[string]string{defaults.DryRunAnnotationKey: "false"}},
+		Spec:       configv1.OperatorHubSpec{DisableAllDefaultSources: true},
+	}
+	require.NoError(t, c.Create(ctx, hub))
+	handled(t)(HandleDeletion(ctx, c, recorder))
+	assert.True(t, k8sErrors.IsNotFound(c.Get(ctx, key, &olmv1alpha1.CatalogSource{})))
+	assert.True(t, GetSingleton().Get()["redhat-operators"])
+	assert.False(t, deleted())
+
+	// Once it is gone, its configuration is dropped, the default
+	// CatalogSources are restored and the deletion is reported
+	require.NoError(t, c.Delete(ctx, hub))
+	handled(t)(HandleDeletion(ctx, c, recorder))
+	require.NoError(t, c.Get(ctx, key, &olmv1alpha1.CatalogSource{}))
+	assert.False(t, GetSingleton().Get()["redhat-operators"])
+	message, present := status.Notice(DeletedReason)
+	assert.True(t, present)
+	assert.Equal(t, "The cluster OperatorHub does not exist. The default CatalogSources are reconciled with the default configuration until it is recreated.", message)
+
+	// until the recreated OperatorHub is handled
+	hub = &configv1.OperatorHub{ObjectMeta: metav1.ObjectMeta{Name: DefaultName}}
+	require.NoError(t, c.Create(ctx, hub))
+	_, err := NewHandler(c, recorder).Handle(ctx, hub)
+	require.NoError(t, err)
+	assert.False(t, deleted())
+}
+
+func TestParseConfigMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   map[string]string
+		spec   configv1.OperatorHubSpec
+		status configv1.OperatorHubStatus
+		err    string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "yaml",
+			data: map[string]string{ConfigMapSpecKey: "disableAllDefaultSources: true\nsources:\n- name: redhat-operators\n  disabled: false\n"},
+			spec: configv1.OperatorHubSpec{DisableAllDefaultSources: true, Sources: []configv1.HubSource{{Name: "redhat-operators"}}},
+		},
+		{
+			name: "json",
+			data: map[string]string{ConfigMapSpecKey: `{"sources": [{"name": "redhat-operators", "disabled": true}]}`},
+			spec: configv1.OperatorHubSpec{Sources: []configv1.HubSource{{Name: "redhat-operators", Disabled: true}}},
+		},
+		{
+			name: "status",
+			data: map[string]string{ConfigMapStatusKey: "sources:\n- name: redhat-operators\n  status: Success\n"},
+			status: configv1.OperatorHubStatus{Sources: []configv1.HubSourceStatus{{
+				HubSource: configv1.HubSource{Name: "redhat-operators"},
+				Status:    "Success",
+			}}},
+		},
+		{
+			name: "invalid status",
+			data: map[string]string{ConfigMapSpecKey: "disableAllDefaultSources: true", ConfigMapStatusKey: "sources: none"},
+			spec: configv1.OperatorHubSpec{DisableAllDefaultSources: true},
+		},
+		{
+			name: "unknown field",
+			data: map[string]string{ConfigMapSpecKey: "disableAllDefaultSource: true"},
+			err:  `invalid spec key of ConfigMap marketplace-operatorhub: error unmarshaling JSON: while decoding JSON: json: unknown field "disableAllDefaultSource"`,
+		},
+		{
+			name: "invalid spec",
+			data: map[string]string{ConfigMapSpecKey: "sources: all"},
+			err:  "invalid spec key of ConfigMap marketplace-operatorhub",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: testNamespace, Annotations: map[string]string{defaults.DryRunAnnotationKey: "true"}},
+				Data:       tt.data,
+			}
+			in, err := ParseConfigMap(cm)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, ConfigMapName, in.Name)
+			assert.Equal(t, cm.Annotations, in.Annotations)
+			assert.Equal(t, tt.spec, in.Spec)
+			assert.Equal(t, tt.status, in.Status)
+		})
+	}
+}
+
+func TestHandleConfigMap(t *testing.T) {
+	setupDefaults(t)
+	ctx := context.TODO()
+	conflicts := 0
+	var concurrent func(c client.WithWatch)
+	c := newClientBuilder(t).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if concurrent != nil {
+				concurrent(c)
+				concurrent = nil
+			}
+			err := c.Patch(ctx, obj, patch, opts...)
+			if k8sErrors.IsConflict(err) {
+				conflicts++
+			}
+			return err
+		},
+	}).Build()
+	key := client.ObjectKey{Name: "redhat-operators", Namespace: testNamespace}
+	cmKey := client.ObjectKey{Name: ConfigMapName, Namespace: testNamespace}
+	UseConfigMap(c, testNamespace)
+	handle := func(spec string) *corev1.ConfigMap {
+		t.Helper()
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, c.Get(ctx, cmKey, cm))
+		cm.Data[ConfigMapSpecKey] = spec
+		require.NoError(t, c.Update(ctx, cm))
+		handled(t)(HandleConfigMap(ctx, c, events.NewFakeRecorder(100), cm))
+		require.NoError(t, c.Get(ctx, cmKey, cm))
+		return cm
+	}
+	status := func(cm *corev1.ConfigMap) configv1.OperatorHubStatus {
+		t.Helper()
+		status := configv1.OperatorHubStatus{}
+		require.NoError(t, yaml.Unmarshal([]byte(cm.Data[ConfigMapStatusKey]), &status))
+		return status
+	}
+	require.NoError(t, c.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: testNamespace},
+		Data:       map[string]string{ConfigMapSpecKey: ""},
+	}))
+
+	// The spec is applied and the status written to its own key only
+	cm := handle("disableAllDefaultSources: true")
+	assert.True(t, k8sErrors.IsNotFound(c.Get(ctx, key, &olmv1alpha1.CatalogSource{})))
+	assert.Equal(t, "disableAllDefaultSources: true", cm.Data[ConfigMapSpecKey])
+	require.Len(t, status(cm).Sources, 1)
+	assert.True(t, status(cm).Sources[0].Disabled)
+
+	// A spec that cannot be parsed keeps the last configuration and is
+	// reported in the message of every default CatalogSource
+	err := func() error {
+		cm.Data[ConfigMapSpecKey] = "disableAllDefaultSource: false"
+		require.NoError(t, c.Update(ctx, cm))
+		failures, _, err := handleConfigMap(ctx, c, events.NewFakeRecorder(100), cm)
+		require.NoError(t, err)
+		require.Len(t, failures, 1)
+		return failures[0]
+	}()
+	assert.Contains(t, err.Error(), `unknown field "disableAllDefaultSource"`)
+	assert.True(t, k8sErrors.IsNotFound(c.Get(ctx, key, &olmv1alpha1.CatalogSource{})))
+	require.NoError(t, c.Get(ctx, cmKey, cm))
+	require.Len(t, status(cm).Sources, 1, "the spec key is not reported as a source")
+	assert.Equal(t, "redhat-operators", status(cm).Sources[0].Name)
+	assert.True(t, status(cm).Sources[0].Disabled)
+	assert.Contains(t, status(cm).Sources[0].Message, "InvalidSpec: the last configuration is kept as the spec key cannot be parsed")
+
+	// A status that conflicts with another writer is written again to the
+	// latest version of the ConfigMap
+	concurrent = func(c client.WithWatch) {
+		latest := &corev1.ConfigMap{}
+		require.NoError(t, c.Get(ctx, cmKey, latest))
+		latest.Labels = map[string]string{"example.com/team": "catalogs"}
+		require.NoError(t, c.Update(ctx, latest))
+	}
+	cm = handle("disableAllDefaultSources: false")
+	assert.Equal(t, 1, conflicts)
+	require.NoError(t, c.Get(ctx, key, &olmv1alpha1.CatalogSource{}))
+	assert.Equal(t, "catalogs", cm.Labels["example.com/team"])
+	require.Len(t, status(cm).Sources, 1)
+	assert.False(t, status(cm).Sources[0].Disabled)
+
+	// but not once the spec it was computed from changed
+	concurrent = func(c client.WithWatch) {
+		latest := &corev1.ConfigMap{}
+		require.NoError(t, c.Get(ctx, cmKey, latest))
+		latest.Data[ConfigMapSpecKey] = "disableAllDefaultSources: false"
+		require.NoError(t, c.Update(ctx, latest))
+	}
+	cm = handle("disableAllDefaultSources: true")
+	assert.Equal(t, 2, conflicts)
+	assert.Equal(t, "disableAllDefaultSources: false", cm.Data[ConfigMapSpecKey])
+	assert.False(t, status(cm).Sources[0].Disabled, "the status of the previous spec is kept")
+}
+
+func TestHandleConfigMapDeletion(t *testing.T) {
+	setupDefaults(t)
+	ctx := context.TODO()
+	c := newClientBuilder(t).Build()
+	recorder := events.NewFakeRecorder(100)
+	key := client.ObjectKey{Name: "redhat-operators", Namespace: testNamespace}
+	UseConfigMap(c, testNamespace)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: testNamespace},
+		Data:       map[string]string{ConfigMapSpecKey: "disableAllDefaultSources: true"},
+	}
+	require.NoError(t, c.Create(ctx, cm))
+	handled(t)(HandleConfigMap(ctx, c, recorder, cm))
+	assert.True(t, k8sErrors.IsNotFound(c.Get(ctx, key, &olmv1alpha1.CatalogSource{})))
+
+	// A ConfigMap that is found again is handled rather than reset
+	handled(t)(HandleConfigMapDeletion(ctx, c, recorder))
+	assert.True(t, k8sErrors.IsNotFound(c.Get(ctx, key, &olmv1alpha1.CatalogSource{})))
+	assert.True(t, GetSingleton().Get()["redhat-operators"])
+
+	// Once it is gone, the default configuration applies again
+	require.NoError(t, c.Delete(ctx, cm))
+	handled(t)(HandleConfigMapDeletion(ctx, c, recorder))
+	require.NoError(t, c.Get(ctx, key, &olmv1alpha1.CatalogSource{}))
+	assert.False(t, GetSingleton().Get()["redhat-operators"])
+}
@@ -0,0 +1,82 @@
+package operatorhub
+
+import (
+	"context"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	mktconfig "github.com/operator-framework/operator-marketplace/pkg/apis/config/v1"
+	"github.com/operator-framework/operator-marketplace/pkg/defaults"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Refresh re-applies the OperatorHub configuration to the current set of
+// default CatalogSource definitions. It is used when the definitions change
+// while the operator is running. If the cluster OperatorHub is present it is
+// handled as usual so that its status reflects the new definitions, as is the
+// OperatorHub ConfigMap on clusters without the config API. Otherwise the last
+// known configuration is reapplied. It returns an error unless every
+// default CatalogSource was ensured successfully, along with the shortest
+// delay after which one of them asked to be ensured again, 0 if none did.
+func Refresh(ctx context.Context, c client.Client, recorder events.EventRecorder) (time.Duration, error) {
+	if mktconfig.IsAPIAvailable() {
+		in := &configv1.OperatorHub{}
+		err := c.Get(ctx, client.ObjectKey{Name: DefaultName}, in)
+		if err == nil {
+			h := &confighandler{client: c, recorder: recorder}
+			failures, requeueAfter, err := h.handle(ctx, in)
+			if err != nil {
+				return requeueAfter, err
+			}
+			return requeueAfter, utilerrors.NewAggregate(failures)
+		}
+		if !apierrors.IsNotFound(err) {
+			return 0, err
+		}
+	} else {
+		cm, err := getConfigMap(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if cm != nil {
+			failures, requeueAfter, err := handleConfigMap(ctx, c, recorder, cm)
+			if err != nil {
+				return requeueAfter, err
+			}
+			return requeueAfter, utilerrors.NewAggregate(failures)
+		}
+	}
+
+	current := GetSingleton()
+	current.Refresh()
+	catsrcDefinitions := defaults.GetGlobalCatalogSourceDefinitions()
+	companions := defaults.GetGlobalCompanions()
+	d := defaults.New(catsrcDefinitions, current.Get(),
+		defaults.WithCompanions(companions),
+		defaults.WithOverrides(current.GetOverrides()),
+		defaults.WithEventRecorder(recorder, nil),
+		defaults.WithDryRun(current.GetDryRun()),
+	)
+	result := d.EnsureAll(ctx, c)
+	orphans, sweepErr := d.SweepOrphans(ctx, c)
+	failures, requeueAfter := ensureFailures(logrus.WithField("name", DefaultName), result, orphans, sweepErr)
+	return requeueAfter, utilerrors.NewAggregate(failures)
+}
+
+// Requeue returns the result of a reconcile that applied the OperatorHub
+// configuration through Refresh, HandleDeletion or HandleConfigMapDeletion.
+// Like for the default CatalogSources themselves, the reconcile is requeued
+// after requeueAfter rather than retried by the worker when one of them asked
+// to be ensured again.
+func Requeue(requeueAfter time.Duration, err error) (reconcile.Result, error) {
+	if err != nil && requeueAfter > 0 {
+		logrus.Warnf("[operatorhub] Requeuing the OperatorHub configuration in %s - %v", requeueAfter, err)
+		return reconcile.Result{RequeueAfter: requeueAfter}, nil
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, err
+}
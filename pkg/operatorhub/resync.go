@@ -0,0 +1,71 @@
+package operatorhub
+
+import (
+	"context"
+	"time"
+
+	"github.com/operator-framework/operator-marketplace/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// NewResync returns a Runnable that reapplies the OperatorHub configuration to
+// the default CatalogSources every interval. It heals the drift that was
+// missed by the watches, for example during a leader failover.
+func NewResync(client client.Client, recorder events.EventRecorder, interval time.Duration) manager.Runnable {
+	return &resync{
+		client:   client,
+		recorder: recorder,
+		interval: interval,
+	}
+}
+
+type resync struct {
+	client   client.Client
+	recorder events.EventRecorder
+	interval time.Duration
+	// lastSuccess is the time of the last resync in which every default
+	// CatalogSource was ensured successfully
+	lastSuccess time.Time
+}
+
+// Start runs the resync until the context is done. A resync in which default
+// CatalogSources asked to be ensured again is run again after the delay they
+// asked for when it is shorter than the interval.
+func (r *resync) Start(ctx context.Context) error {
+	logrus.Infof("[resync] Resyncing the default CatalogSources every %s", r.interval)
+	timer := time.NewTimer(r.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			timer.Reset(r.run(ctx))
+		}
+	}
+}
+
+// run reapplies the OperatorHub configuration once and returns how long after
+// it is to be run again
+func (r *resync) run(ctx context.Context) time.Duration {
+	next := r.interval
+	requeueAfter, err := Refresh(ctx, r.client, r.recorder)
+	if requeueAfter > 0 && requeueAfter < next {
+		next = requeueAfter
+	}
+	if err != nil {
+		if r.lastSuccess.IsZero() {
+			logrus.Warnf("[resync] Failed to resync the default CatalogSources, no resync has succeeded yet, retrying in %s - %v", next, err)
+		} else {
+			logrus.Warnf("[resync] Failed to resync the default CatalogSources, the last successful resync was %s ago, retrying in %s - %v", time.Since(r.lastSuccess).Round(time.Second), next, err)
+		}
+		return next
+	}
+	r.lastSuccess = time.Now()
+	metrics.DefaultCatalogLastSuccessfulResync.SetToCurrentTime()
+	logrus.Info("[resync] Resynced the default CatalogSources")
+	return next
+}
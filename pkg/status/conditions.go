@@ -0,0 +1,147 @@
+package status
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+// multipleReasons is the reason reported on a condition when it has been set
+// for more than one reason.
+const multipleReasons = "MultipleReasons"
+
+// reasons tracks why a ClusterOperator condition should be reported as
+// abnormal. It is safe for concurrent use.
+type reasons struct {
+	lock     sync.Mutex
+	messages map[string]string
+}
+
+func newReasons() *reasons {
+	return &reasons{messages: make(map[string]string)}
+}
+
+func (r *reasons) set(reason, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.messages[reason] = message
+}
+
+func (r *reasons) clear(reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.messages, reason)
+}
+
+// get returns the reason and message to report. It returns an empty reason
+// if none are set.
+func (r *reasons) get() (string, string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.messages) == 0 {
+		return "", ""
+	}
+	keys := make([]string, 0, len(r.messages))
+	for reason := range r.messages {
+		keys = append(keys, reason)
+	}
+	sort.Strings(keys)
+	if len(keys) == 1 {
+		return keys[0], r.messages[keys[0]]
+	}
+
+	messages := make([]string, 0, len(keys))
+	for _, reason := range keys {
+		messages = append(messages, reason+": "+r.messages[reason])
+	}
+	return multipleReasons, strings.Join(messages, "\n")
+}
+
+// degraded holds the reasons the operator is currently degraded for
+var degraded = newReasons()
+
+// SetDegraded reports the operator as Degraded for the given reason until it
+// is cleared. Setting the same reason again replaces its message.
+func SetDegraded(reason, message string) {
+	degraded.set(reason, message)
+}
+
+// ClearDegraded clears the given Degraded reason
+func ClearDegraded(reason string) {
+	degraded.clear(reason)
+}
+
+// notUpgradeable holds the reasons the operator is currently not upgradeable
+// for
+var notUpgradeable = newReasons()
+
+// SetNotUpgradeable reports the operator as not Upgradeable for the given
+// reason until it is cleared. Setting the same reason again replaces its
+// message.
+func SetNotUpgradeable(reason, message string) {
+	notUpgradeable.set(reason, message)
+}
+
+// ClearNotUpgradeable clears the given not Upgradeable reason
+func ClearNotUpgradeable(reason string) {
+	notUpgradeable.clear(reason)
+}
+
+// notices holds the reasons reported in the Available condition about states
+// of the operator that are worth knowing of but neither degrade it nor block
+// its upgrades
+var notices = newReasons()
+
+// SetNotice reports the given reason in the Available condition until it is
+// cleared, without degrading the operator. Setting the same reason again
+// replaces its message.
+func SetNotice(reason, message string) {
+	notices.set(reason, message)
+}
+
+// ClearNotice clears the given notice
+func ClearNotice(reason string) {
+	notices.clear(reason)
+}
+
+// Notice returns the message of the given notice, and whether it is set
+func Notice(reason string) (string, bool) {
+	notices.lock.Lock()
+	defer notices.lock.Unlock()
+	message, present := notices.messages[reason]
+	return message, present
+}
+
+// availableCondition returns the message and reason of the Available
+// condition, which is always true. The notices are appended to
+// availableMessage.
+func availableCondition(availableMessage string) (string, string) {
+	reason, message := notices.get()
+	if reason == "" {
+		return availableMessage, operatorAvailable
+	}
+	return availableMessage + "\n" + message, reason
+}
+
+// upgradeableCondition returns the status, message and reason of the
+// Upgradeable condition
+func upgradeableCondition() (configv1.ConditionStatus, string, string) {
+	reason, message := notUpgradeable.get()
+	if reason == "" {
+		return configv1.ConditionTrue, upgradeable, operatorAvailable
+	}
+	return configv1.ConditionFalse, message, reason
+}
+
+// degradedCondition returns the status, message and reason of the Degraded
+// condition. healthyMessage is used when the operator is not degraded.
+func degradedCondition(healthyMessage string) (configv1.ConditionStatus, string, string) {
+	reason, message := degraded.get()
+	if reason == "" {
+		return configv1.ConditionFalse, healthyMessage, operatorAvailable
+	}
+	return configv1.ConditionTrue, message, reason
+}
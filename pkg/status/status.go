@@ -192,9 +192,12 @@ func (r *reporter) monitorClusterStatus() {
 	if r.clusterOperator == nil {
 		conditionListBuilder := clusterStatusListBuilder()
 		conditionListBuilder(configv1.OperatorProgressing, configv1.ConditionFalse, fmt.Sprintf("Successfully progressed to release version: %s", r.version), operatorAvailable)
-		conditionListBuilder(configv1.OperatorAvailable, configv1.ConditionTrue, msg, operatorAvailable)
-		conditionListBuilder(configv1.OperatorUpgradeable, configv1.ConditionTrue, upgradeable, operatorAvailable)
-		statusConditions := conditionListBuilder(configv1.OperatorDegraded, configv1.ConditionFalse, msg, operatorAvailable)
+		availableMessage, availableReason := availableCondition(msg)
+		conditionListBuilder(configv1.OperatorAvailable, configv1.ConditionTrue, availableMessage, availableReason)
+		upgradeableStatus, upgradeableMessage, upgradeableReason := upgradeableCondition()
+		conditionListBuilder(configv1.OperatorUpgradeable, upgradeableStatus, upgradeableMessage, upgradeableReason)
+		degradedStatus, degradedMessage, degradedReason := degradedCondition(msg)
+		statusConditions := conditionListBuilder(configv1.OperatorDegraded, degradedStatus, degradedMessage, degradedReason)
 		statusErr := r.setStatus(statusConditions)
 		if statusErr != nil {
 			log.Error("[status] " + statusErr.Error())
@@ -218,9 +221,12 @@ func (r *reporter) monitorClusterStatus() {
 			// Report that marketplace is available
 			conditionListBuilder := clusterStatusListBuilder()
 			conditionListBuilder(configv1.OperatorProgressing, configv1.ConditionFalse, fmt.Sprintf("Successfully progressed to release version: %s", r.version), operatorAvailable)
-			conditionListBuilder(configv1.OperatorDegraded, configv1.ConditionFalse, msg, operatorAvailable)
-			conditionListBuilder(configv1.OperatorUpgradeable, configv1.ConditionTrue, upgradeable, operatorAvailable)
-			statusConditions := conditionListBuilder(configv1.OperatorAvailable, configv1.ConditionTrue, msg, operatorAvailable)
+			degradedStatus, degradedMessage, degradedReason := degradedCondition(msg)
+			conditionListBuilder(configv1.OperatorDegraded, degradedStatus, degradedMessage, degradedReason)
+			upgradeableStatus, upgradeableMessage, upgradeableReason := upgradeableCondition()
+			conditionListBuilder(configv1.OperatorUpgradeable, upgradeableStatus, upgradeableMessage, upgradeableReason)
+			availableMessage, availableReason := availableCondition(msg)
+			statusConditions := conditionListBuilder(configv1.OperatorAvailable, configv1.ConditionTrue, availableMessage, availableReason)
 			statusErr = r.setStatus(statusConditions)
 		}
 	}